@@ -0,0 +1,300 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pascaldekloe/jwt"
+	"net/http"
+	"time"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+// accountExport is everything we store about a customer, as handed out by
+// GET /user/export.
+type accountExport struct {
+	ExportedAt   time.Time             `json:"exported_at"`
+	Profile      *data.User            `json:"profile"`
+	Addresses    []*data.Address       `json:"addresses"`
+	Orders       []*accountExportOrder `json:"orders"`
+	Returns      []*data.ReturnRequest `json:"returns"`
+	LoginHistory []*data.LoginEvent    `json:"login_history"`
+	Identities   []*data.UserIdentity  `json:"identities"`
+	APIKeys      []*data.APIKey        `json:"api_keys"`
+}
+
+type accountExportOrder struct {
+	*data.OrderDetail
+	Items    []*data.OrderItem `json:"items"`
+	Payments []*data.Payment   `json:"payments"`
+	Refunds  []*data.Refund    `json:"refunds"`
+}
+
+type accountDeletionEmailData struct {
+	FirstName string
+	Token     string
+	Until     time.Time
+}
+
+type DeleteAccountRequest struct {
+	Token string `json:"token"`
+}
+
+// accountDeletionTTL is how long the confirmation sent by POST /user/deletion is valid.
+const accountDeletionTTL = time.Hour
+
+func (app *application) exportAccount(user *data.User) (*accountExport, error) {
+	export := &accountExport{ExportedAt: time.Now(), Profile: user, Orders: []*accountExportOrder{}}
+	var err error
+	export.Addresses, err = app.models.Address.GetAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := app.models.OrderDetail.GetAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		o := &accountExportOrder{OrderDetail: order}
+		o.Items, err = app.models.OrderItem.GetAllByOrderID(order.Id)
+		if err != nil {
+			return nil, err
+		}
+		o.Payments, err = app.models.Payments.GetAllByOrderID(order.Id)
+		if err != nil {
+			return nil, err
+		}
+		o.Refunds, err = app.models.Refunds.GetAllByOrderID(order.Id)
+		if err != nil {
+			return nil, err
+		}
+		export.Orders = append(export.Orders, o)
+	}
+	export.Returns, err = app.models.ReturnRequests.GetAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.LoginHistory, err = app.models.LoginEvents.GetAllByUserID(user.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	export.Identities, err = app.models.UserIdentities.GetAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.APIKeys, err = app.models.APIKeys.GetAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// zip packs the export with one JSON file per section.
+func (e *accountExport) zip() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", e.Profile},
+		{"addresses.json", e.Addresses},
+		{"orders.json", e.Orders},
+		{"returns.json", e.Returns},
+		{"login_history.json", e.LoginHistory},
+		{"identities.json", e.Identities},
+		{"api_keys.json", e.APIKeys},
+	}
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: e.ExportedAt})
+		if err != nil {
+			return nil, err
+		}
+		js, err := json.MarshalIndent(file.data, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(js)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// @Summary Export personal data
+// @Description Download everything stored about the current user: profile, addresses, orders with their items, payments and refunds, returns, sign-in history, linked identities and API keys. format=zip returns a ZIP archive with one JSON file per section instead of a single JSON document.
+// @Tags users
+// @Produce json
+// @Produce application/zip
+// @Security ApiKeyAuth
+// @Param format query string false "json (default) or zip"
+// @Success 200 {object} accountExport
+// @Router /user/export [get]
+func (app *application) exportAccountHandler(w http.ResponseWriter, r *http.Request) {
+	format := app.readString(r.URL.Query(), "format", "json")
+	v := validator.New()
+	if v.Check(validator.PermittedValue(format, "json", "zip"), "format", "must be json or zip"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	export, err := app.exportAccount(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	filename := "youneon-export-" + export.ExportedAt.Format("2006-01-02")
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	if format == "json" {
+		headers.Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	archive, err := export.zip()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+// accountDeletionAudience is the audience of the tokens confirming an account
+// deletion, so they are never accepted anywhere else.
+func (app *application) accountDeletionAudience() string {
+	return app.config.jwt.issuer + "/account-deletion"
+}
+
+func (app *application) newAccountDeletionToken(user *data.User, expires time.Time) ([]byte, error) {
+	var claims jwt.Claims
+	claims.Subject = user.ID.String()
+	claims.Issued = jwt.NewNumericTime(time.Now())
+	claims.NotBefore = jwt.NewNumericTime(time.Now())
+	claims.Expires = jwt.NewNumericTime(expires)
+	claims.Issuer = app.config.jwt.issuer
+	claims.Audiences = []string{app.accountDeletionAudience()}
+	return app.keys.Sign(&claims)
+}
+
+// checkAccountDeletionToken reports whether token confirms the deletion of the
+// account of user.
+func (app *application) checkAccountDeletionToken(user *data.User, token string) bool {
+	claims, err := app.keys.Check([]byte(token))
+	if err != nil {
+		return false
+	}
+	if !claims.Valid(time.Now()) || claims.Issuer != app.config.jwt.issuer || !claims.AcceptAudience(app.accountDeletionAudience()) {
+		return false
+	}
+	id, err := uuid.Parse(claims.Subject)
+	return err == nil && id == user.ID
+}
+
+// @Summary Request the deletion of the account
+// @Description Email the current user a token confirming the deletion of their account, valid for an hour. The deletion itself is done by DELETE /user. Admin accounts can't be deleted.
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 202 {object} map[string]string
+// @Router /user/deletion [post]
+func (app *application) requestAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.IsAdmin() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	until := time.Now().Add(accountDeletionTTL)
+	token, err := app.newAccountDeletionToken(user, until)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	msg, err := data.NewEmailMessage(user.Email, user.Language, "account_deletion.tmpl", accountDeletionEmailData{
+		FirstName: user.FirstName,
+		Token:     string(token),
+		Until:     until,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.EmailOutbox.Insert(msg)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "we emailed you a token to confirm the deletion of your account"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete the account
+// @Description Close the current user's account with the token emailed by POST /user/deletion. Personal data in the profile, addresses, sent emails and return requests is erased, and carts, second factors, linked identities, API keys, the sign-in history and unsent emails are deleted. Orders, payments, refunds and returns are kept for accounting, no longer linked to any personal data but the delivery address of each order.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param confirmation body DeleteAccountRequest true "token from the confirmation email"
+// @Success 200 {object} map[string]string
+// @Router /user [delete]
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input DeleteAccountRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if user.IsAdmin() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Token != "", "token", "must be provided")
+	if v.Valid() {
+		v.Check(app.checkAccountDeletionToken(user, input.Token), "token", "invalid or expired confirmation token")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Users.Anonymize(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Other sessions are turned away by authenticate() now the account is closed;
+	// this one is logged out like any other.
+	err = app.redis.storeLogoutToken(app.contextGetToken(r), user.ID.String(), app.config.jwt.ttl)
+	if err != nil {
+		app.logError(r, err)
+	}
+	app.endSession(w)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+)
+
+// @Summary Catalogue cache metrics
+// @Description Hits, misses and store errors of each cached read, and the invalidations
+// @Tags admin
+// @Produce json
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/cache [get]
+func (app *application) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.cache == nil {
+		app.errorResponse(w, r, http.StatusNotFound, "the catalogue cache is disabled with -cache-store=none")
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"cache": app.cache.Stats()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Clear the catalogue cache
+// @Description Drop every cached read, for changes made to the database directly
+// @Tags admin
+// @Produce json
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/cache [delete]
+func (app *application) clearCacheHandler(w http.ResponseWriter, r *http.Request) {
+	app.cache.Invalidate()
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "catalogue cache cleared"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+type CategoryRequest struct {
+	Name        *string `json:"name"`
+	Slug        *string `json:"slug"`
+	ParentId    *string `json:"parent_id"`
+	Description *string `json:"description"`
+	Image       *string `json:"image"`
+	SortOrder   *int    `json:"sort_order"`
+}
+
+// apply copies the fields which were sent onto the category. An empty parent_id
+// moves the category to the top level, an empty description or image clears it.
+func (input CategoryRequest) apply(category *data.Category, v *validator.Validator) {
+	if input.Name != nil {
+		category.Name = *input.Name
+	}
+	if input.Slug != nil {
+		category.Slug = *input.Slug
+	}
+	if input.ParentId != nil {
+		category.ParentId = nil
+		if *input.ParentId != "" {
+			parentId, err := uuid.Parse(*input.ParentId)
+			v.Check(err == nil, "parent_id", "must be a valid id")
+			category.ParentId = &parentId
+		}
+	}
+	if input.Description != nil {
+		category.Description = input.Description
+		if *input.Description == "" {
+			category.Description = nil
+		}
+	}
+	if input.Image != nil {
+		category.Image = input.Image
+		if *input.Image == "" {
+			category.Image = nil
+		}
+	}
+	if input.SortOrder != nil {
+		category.SortOrder = *input.SortOrder
+	}
+}
+
+// validateCategoryParent checks that the parent exists and isn't the category
+// or one of its subcategories.
+func (app *application) validateCategoryParent(v *validator.Validator, category *data.Category) error {
+	if category.ParentId == nil || !v.Valid() {
+		return nil
+	}
+	categories, err := app.models.Categories.GetAllWithDeleted()
+	if err != nil {
+		return err
+	}
+	parents := make(map[uuid.UUID]*data.Category, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c
+	}
+	parent, ok := parents[*category.ParentId]
+	if !ok || parent.IsDeleted {
+		v.AddError("parent_id", "unknown category")
+		return nil
+	}
+	for i := 0; parent != nil && i < len(categories); i++ {
+		if parent.ID == category.ID {
+			v.AddError("parent_id", "must not be one of the subcategories of the category")
+			return nil
+		}
+		parent = parents[derefUUID(parent.ParentId)]
+	}
+	return nil
+}
+
+func derefUUID(id *uuid.UUID) uuid.UUID {
+	if id == nil {
+		return uuid.Nil
+	}
+	return *id
+}
+
+// @Summary List all categories
+// @Description List every category as a flat list, deleted ones included
+// @Tags admin
+// @Produce json
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/categories [get]
+func (app *application) adminListCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Categories.GetAllWithDeleted()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": categories}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Create a category
+// @Description Create a category. The slug is made from the name when it is left out.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param category body CategoryRequest true "category"
+// @Success 201 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/categories [post]
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input CategoryRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category := &data.Category{}
+	v := validator.New()
+	input.apply(category, v)
+	if input.Slug == nil {
+		category.Slug = data.Slugify(category.Name)
+	}
+	data.ValidateCategory(v, category)
+	err = app.validateCategoryParent(v, category)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Insert(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Update a category
+// @Description Update the fields which are sent. An empty parent_id moves the category to the top level.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "category id"
+// @Param category body CategoryRequest true "category"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/categories/{id} [patch]
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	category, err := app.models.Categories.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input CategoryRequest
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	input.apply(category, v)
+	data.ValidateCategory(v, category)
+	err = app.validateCategoryParent(v, category)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Update(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete a category
+// @Description Soft-delete a category which has no subcategories left
+// @Tags admin
+// @Produce json
+// @Param id path string true "category id"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/categories/{id} [delete]
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	hasSubcategories, err := app.models.Categories.HasSubcategories(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if hasSubcategories {
+		v := validator.New()
+		v.AddError("category", "has subcategories, move or delete them first")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Restore a category
+// @Description Restore a deleted category
+// @Tags admin
+// @Produce json
+// @Param id path string true "category id"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/categories/{id}/restore [post]
+func (app *application) restoreCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	category, err := app.models.Categories.Get(id)
+	if err == nil {
+		err = app.models.Categories.Restore(category)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
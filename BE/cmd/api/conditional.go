@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// writeCatalogueJSON writes a 200 response like writeJSON with the validators and
+// cache policy of the catalogue routes. The ETag is a hash of the body, and
+// Last-Modified is only sent when lastModified isn't zero. A client which already
+// has the response, according to If-None-Match or If-Modified-Since, gets a 304
+// without the body.
+//
+// Anonymous responses can be kept by shared caches for -http-max-age. The
+// catalogue is the same for everyone, but a cache must not store a response to an
+// authenticated request, so those are private and revalidated on each use.
+func (app *application) writeCatalogueJSON(w http.ResponseWriter, r *http.Request, data envelope, lastModified time.Time) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	sum := sha256.Sum256(js)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		lastModified = lastModified.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if app.contextGetUser(r).IsAnonymous() {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(app.config.httpCache.maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+	return nil
+}
+
+// notModified evaluates the preconditions of a GET. If-Modified-Since is ignored
+// when the request has an If-None-Match, which compares weakly, so a W/ prefix
+// added by a proxy still matches.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.After(since)
+}
@@ -8,7 +8,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey         = contextKey("user")
+	secondFactorContextKey = contextKey("secondFactor")
+	apiKeyContextKey       = contextKey("apiKey")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -34,3 +38,27 @@ func (app *application) contextGetToken(r *http.Request) string {
 	}
 	return token
 }
+
+// contextSetSecondFactor records whether the request's token was issued after the
+// user passed the second factor.
+func (app *application) contextSetSecondFactor(r *http.Request, passed bool) *http.Request {
+	ctx := context.WithValue(r.Context(), secondFactorContextKey, passed)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetSecondFactor(r *http.Request) bool {
+	passed, _ := r.Context().Value(secondFactorContextKey).(bool)
+	return passed
+}
+
+// contextSetAPIKey records the API key a request was authenticated with.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns nil unless the request was authenticated with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+	"youneon-BE/internal/data/mailer"
+)
+
+var debugMailTemplate = template.Must(template.New("mail").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Captured mail</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+article { border: 1px solid #ccc; margin-bottom: 2em; padding: 1em; }
+iframe { width: 100%; height: 400px; border: 1px solid #eee; }
+pre { white-space: pre-wrap; background: #f7f7f7; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>Captured mail ({{len .}})</h1>
+{{range .}}
+<article>
+<h2>{{.Subject}}</h2>
+<p><strong>To:</strong> {{.To}} &middot; <strong>From:</strong> {{.From}} &middot; {{.Date.Format "2006-01-02 15:04:05"}}</p>
+<iframe sandbox srcdoc="{{.HTMLBody}}"></iframe>
+<details><summary>Plain text</summary><pre>{{.PlainBody}}</pre></details>
+</article>
+{{else}}
+<p>No mail has been sent yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// debugMailHandler lists the emails captured by the memory mail transport, newest
+// first. It is only routed with -mail-debug; add ?format=json to get the raw messages.
+func (app *application) debugMailHandler(w http.ResponseWriter, r *http.Request) {
+	if app.mailbox == nil {
+		app.errorResponse(w, r, http.StatusNotFound, "mail is only captured with -mail-transport=memory")
+		return
+	}
+	messages := app.mailbox.Messages()
+	slices.Reverse(messages)
+
+	if r.URL.Query().Get("format") == "json" {
+		err := app.writeJSON(w, http.StatusOK, envelope{"messages": messages}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := debugMailTemplate.Execute(w, messages)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// sampleEmailData is the data used to preview templates.
+func sampleEmailData(templateFile string) any {
+	if templateFile == "user_welcome.tmpl" {
+		return map[string]any{"userID": "b7d4f8c2-3c1e-4a55-9d0e-6f1a2b3c4d5e", "activationToken": "SAMPLETOKEN"}
+	}
+	if templateFile == "account_deletion.tmpl" {
+		return accountDeletionEmailData{FirstName: "Minh", Token: "SAMPLETOKEN", Until: time.Now().Add(accountDeletionTTL)}
+	}
+	if templateFile == "account_locked.tmpl" {
+		return accountLockedEmailData{FirstName: "Minh", IP: "203.0.113.7", Date: time.Now(), Until: time.Now().Add(15 * time.Minute)}
+	}
+	tracking := "GHN123456789VN"
+	return orderEmailData{
+		FirstName:      "Minh",
+		OrderID:        "5f0c2a9e-8d41-4b7a-a1f3-2e6d9c0b7a11",
+		Status:         "shipped",
+		Date:           time.Now(),
+		AddressDetail:  "12 Nguyễn Huệ, Phường Bến Nghé, Quận 1, TP. Hồ Chí Minh",
+		TrackingNumber: tracking,
+		Items: []orderEmailItem{
+			{Name: "Đèn Neon Happy Birthday", Quantity: 1, Price: 1250000, Subtotal: 1250000},
+			{Name: "Neon Wedding Sign", Quantity: 2, Price: 890000, Subtotal: 1780000},
+		},
+		Total:    3030000,
+		Amount:   3030000,
+		Provider: "vnpay",
+	}
+}
+
+// debugMailPreviewHandler renders an email template with sample data so copy and
+// translations can be reviewed without sending anything. Without a template
+// parameter it lists the available templates. It is only routed with -mail-debug.
+//
+//	GET /debug/mail/preview?template=order_confirmation.tmpl&locale=vi&part=html|text|subject
+func (app *application) debugMailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	templateFile := app.readString(qs, "template", "")
+	if templateFile == "" {
+		templates, err := mailer.Templates()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"templates": templates, "locales": mailer.Locales}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	locale := app.readString(qs, "locale", mailer.LocaleVietnamese)
+	msg, err := app.mailer.Render("customer@example.com", locale, templateFile, sampleEmailData(templateFile))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	switch app.readString(qs, "part", "html") {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.PlainBody))
+	case "subject":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.Subject))
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(msg.HTMLBody))
+	}
+}
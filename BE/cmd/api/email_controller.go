@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+// @Summary List queued emails (admin)
+// @Description Inspect the email outbox, optionally filtered by status ("pending", "sending", "sent", "dead")
+// @Tags admin
+// @Produce json
+// @Param status query string false "Status"
+// @Param page query int false "Page"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/emails [get]
+func (app *application) listEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", "")
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "created_at"
+	filters.SortSafelist = []string{"created_at"}
+	v.Check(status == "" || validator.PermittedValue(status, data.EmailStatusPending, data.EmailStatusSending, data.EmailStatusSent, data.EmailStatusDead), "status", "invalid status value")
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	emails, metadata, err := app.models.EmailOutbox.GetAll(status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Retry an email (admin)
+// @Description Put a dead or pending email back in the queue for immediate delivery
+// @Tags admin
+// @Produce json
+// @Param id path string true "Email ID"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/emails/{id}/retry [post]
+func (app *application) retryEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	email, err := app.models.EmailOutbox.Retry(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			// Retry doesn't tell a missing email from one in the wrong state.
+			_, err = app.models.EmailOutbox.Get(id)
+			switch {
+			case err == nil:
+				app.errorResponse(w, r, http.StatusConflict, "only dead or pending emails can be retried")
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+	"youneon-BE/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -43,10 +46,46 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Idempotency-Key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with the same Idempotency-Key is still being processed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, wait time.Duration, reason string) {
+	message := "too many failed sign-in attempts, please try again later"
+	if reason == data.LoginReasonLocked {
+		message = "this account is temporarily locked because of too many failed sign-in attempts"
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "admin accounts must sign in with two-factor authentication, enroll at /user/2fa/enroll and sign in again"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+func (app *application) invalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "missing or invalid CSRF token, send the csrf_token from login in the " + csrfHeader + " header"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+func (app *application) missingScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	message := fmt.Sprintf("the API key needs the %q scope for this resource", scope)
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 	"youneon-BE/internal/validator"
 )
 
@@ -150,8 +151,11 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
+	// Increment the WaitGroup counter so that shutdown waits for this goroutine.
+	app.wg.Add(1)
 	// Launch a background goroutine.
 	go func() {
+		defer app.wg.Done()
 		// Run a deferred function which uses recover() to catch any panic, and log an
 		// error message instead of terminating the application. Because this goroutine can create panic
 		defer func() {
@@ -163,3 +167,25 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// cleanupIdempotencyKeys periodically removes expired Idempotency-Key records. It is
+// meant to be started with background() and runs until app.quit is closed.
+func (app *application) cleanupIdempotencyKeys() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.quit:
+			return
+		case <-ticker.C:
+		}
+		deleted, err := app.models.IdempotencyKeys.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		app.logger.PrintInfo("expired idempotency keys removed", map[string]string{
+			"deleted": strconv.FormatInt(deleted, 10),
+		})
+	}
+}
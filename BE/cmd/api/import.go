@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+// productColumns are the columns of product import and export files. Tags and
+// images hold several values separated by "|"; the first image is the main one.
+var productColumns = []string{"sku", "name", "price", "category", "tags", "images", "description"}
+
+// productListSeparator separates the values of the tags and images columns.
+const productListSeparator = "|"
+
+var errMissingColumns = errors.New("the header row must contain the sku, name and price columns")
+
+// productRow is a data row of an import file, keyed by column name. Line is the
+// line of the row in the file, counting the header as line 1.
+type productRow struct {
+	Line   int
+	Values map[string]string
+}
+
+type importRowResult struct {
+	Line   int               `json:"line"`
+	SKU    string            `json:"sku"`
+	Action string            `json:"action"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importReport tells how each row of an import file was, or in a dry run would
+// be, handled. Nothing is saved when a row has errors.
+type importReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Rows    []importRowResult `json:"rows"`
+}
+
+const (
+	importActionCreate = "create"
+	importActionUpdate = "update"
+	importActionError  = "error"
+)
+
+// readProductFile reads an import file, an Excel workbook when the name ends with
+// .xlsx (only the first sheet is read) and CSV otherwise.
+func readProductFile(name string, r io.Reader) ([]productRow, error) {
+	var records [][]string
+	if strings.EqualFold(filepath.Ext(name), ".xlsx") {
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		records, err = f.GetRows(f.GetSheetName(0))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		// Excel writes a byte order mark at the start of UTF-8 CSV files.
+		content = bytes.TrimPrefix(content, []byte("\ufeff"))
+		cr := csv.NewReader(bytes.NewReader(content))
+		cr.FieldsPerRecord = -1
+		records, err = cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(records) == 0 {
+		return nil, errMissingColumns
+	}
+
+	header := map[int]string{}
+	for i, column := range records[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+	}
+	found := map[string]bool{}
+	for _, column := range header {
+		found[column] = true
+	}
+	if !found["sku"] || !found["name"] || !found["price"] {
+		return nil, errMissingColumns
+	}
+	var rows []productRow
+	for i, record := range records[1:] {
+		row := productRow{Line: i + 2, Values: map[string]string{}}
+		empty := true
+		for j, value := range record {
+			value = strings.TrimSpace(value)
+			if column, ok := header[j]; ok {
+				row.Values[column] = value
+			}
+			empty = empty && value == ""
+		}
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func splitProductList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, productListSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// importProducts validates the rows and, unless it is a dry run or a row has
+// errors, creates or updates the products in one transaction. Categories have to
+// exist and are looked up by name or slug, missing tags are created.
+func importProducts(models data.Models, rows []productRow, dryRun bool) (*importReport, error) {
+	categories, err := models.Categories.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	categoryIds := map[string]*data.Category{}
+	for _, c := range categories {
+		categoryIds[strings.ToLower(c.Name)] = c
+		categoryIds[c.Slug] = c
+	}
+	skus := make([]string, 0, len(rows))
+	for _, row := range rows {
+		skus = append(skus, row.Values["sku"])
+	}
+	existing, err := models.Products.ExistingSKUs(skus)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &importReport{DryRun: dryRun, Rows: []importRowResult{}}
+	imports := make([]*data.ProductImport, 0, len(rows))
+	seen := map[string]int{}
+	for _, row := range rows {
+		sku := row.Values["sku"]
+		result := importRowResult{Line: row.Line, SKU: sku, Action: importActionCreate}
+		if existing[sku] {
+			result.Action = importActionUpdate
+		}
+		product := &data.Product{Sku: &sku, Name: row.Values["name"]}
+		v := validator.New()
+		v.Check(sku != "", "sku", "must be provided")
+		v.Check(len(sku) <= 64, "sku", "must not be more than 64 bytes long")
+		if line, ok := seen[sku]; ok && sku != "" {
+			v.AddError("sku", fmt.Sprintf("is also used on line %d", line))
+		}
+		seen[sku] = row.Line
+		price, err := strconv.Atoi(row.Values["price"])
+		if err != nil {
+			v.AddError("price", "must be an integer")
+		}
+		product.Price = price
+		data.ValidateProduct(v, product)
+		if name := row.Values["category"]; name != "" {
+			category, ok := categoryIds[strings.ToLower(name)]
+			v.Check(ok, "category", "unknown category")
+			if ok {
+				product.CategoryId = category.ID
+			}
+		}
+		if images := splitProductList(row.Values["images"]); len(images) > 0 {
+			product.Image = &images[0]
+			if gallery := images[1:]; len(gallery) > 0 {
+				product.ImageList = &gallery
+			}
+		}
+		if description := row.Values["description"]; description != "" {
+			product.Description = &description
+		}
+		tags := splitProductList(row.Values["tags"])
+		v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+		for _, tag := range tags {
+			tv := validator.New()
+			if data.ValidateTag(tv, &data.Tag{Name: tag}); !tv.Valid() {
+				v.AddError("tags", strconv.Quote(tag)+" "+tv.Errors["name"])
+			}
+		}
+
+		if !v.Valid() {
+			result.Action = importActionError
+			result.Errors = v.Errors
+			report.Failed++
+		}
+		report.Rows = append(report.Rows, result)
+		imports = append(imports, &data.ProductImport{Product: product, Tags: tags})
+	}
+
+	if dryRun || report.Failed > 0 {
+		for _, result := range report.Rows {
+			report.count(result.Action)
+		}
+		return report, nil
+	}
+	err = models.Products.Import(imports)
+	if err != nil {
+		return nil, err
+	}
+	for i, imp := range imports {
+		if imp.Created {
+			report.Rows[i].Action = importActionCreate
+		} else {
+			report.Rows[i].Action = importActionUpdate
+		}
+		report.count(report.Rows[i].Action)
+	}
+	return report, nil
+}
+
+func (r *importReport) count(action string) {
+	switch action {
+	case importActionCreate:
+		r.Created++
+	case importActionUpdate:
+		r.Updated++
+	}
+}
+
+const importProductsUsage = `usage: api [flags] import-products [-dry-run] FILE`
+
+// runImportProducts runs the import-products subcommand, which imports a CSV or
+// XLSX file like POST /admin/products/import and prints the report.
+func runImportProducts(models data.Models, out io.Writer, args []string) error {
+	fset := flag.NewFlagSet("import-products", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	dryRun := fset.Bool("dry-run", false, "Only validate the file")
+	err := fset.Parse(args)
+	if err != nil || fset.NArg() != 1 {
+		return errors.New(importProductsUsage)
+	}
+	name := fset.Arg(0)
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows, err := readProductFile(name, f)
+	if err != nil {
+		return err
+	}
+	report, err := importProducts(models, rows, *dryRun)
+	if err != nil {
+		return err
+	}
+	js, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(js))
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows have errors, nothing was imported", report.Failed, len(report.Rows))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+	"youneon-BE/internal/data"
+)
+
+// loginWait reports how long the client has to wait before its next sign-in
+// attempt is looked at, and why: the IP address made too many failed attempts
+// (throttled), the account has a few failures in a row and every further attempt
+// has to wait twice as long as the previous one (throttled), or the account is
+// locked.
+func (app *application) loginWait(email, ip string) (time.Duration, string, error) {
+	cfg := app.config.login
+	now := time.Now()
+
+	byIP, err := app.models.LoginEvents.FailuresByIP(ip, now.Add(-cfg.window))
+	if err != nil {
+		return 0, "", err
+	}
+	if byIP.Count >= cfg.ipMaxFailures {
+		if wait := byIP.Last.Add(cfg.window).Sub(now); wait > 0 {
+			return wait, data.LoginReasonThrottled, nil
+		}
+	}
+
+	byEmail, err := app.models.LoginEvents.FailuresByEmail(email, now.Add(-cfg.window))
+	if err != nil {
+		return 0, "", err
+	}
+	if byEmail.Count >= cfg.maxFailures {
+		if wait := byEmail.Last.Add(cfg.lockoutDuration).Sub(now); wait > 0 {
+			return wait, data.LoginReasonLocked, nil
+		}
+		return 0, "", nil
+	}
+	if byEmail.Count >= cfg.delayAfter {
+		if wait := byEmail.Last.Add(loginDelay(byEmail.Count-cfg.delayAfter, cfg.maxDelay)).Sub(now); wait > 0 {
+			return wait, data.LoginReasonThrottled, nil
+		}
+	}
+	return 0, "", nil
+}
+
+// loginDelay is 1s, 2s, 4s... capped at max.
+func loginDelay(n int, max time.Duration) time.Duration {
+	delay := time.Second << min(n, 16)
+	return min(delay, max)
+}
+
+// recordLoginFailure stores a failed password attempt. When it is the attempt which
+// locks the account, the owner is told by email and true is returned. Attempts on a
+// locked account never reach this point, so any failure at or above the threshold
+// starts a new lock.
+func (app *application) recordLoginFailure(event *data.LoginEvent, user *data.User) (bool, error) {
+	failures, err := app.models.LoginEvents.FailuresByEmail(event.Email, time.Now().Add(-app.config.login.window))
+	if err != nil {
+		return false, err
+	}
+	if failures.Count+1 < app.config.login.maxFailures {
+		return false, app.models.LoginEvents.Insert(event)
+	}
+
+	msg, err := data.NewEmailMessage(user.Email, user.Language, "account_locked.tmpl", accountLockedEmailData{
+		FirstName: user.FirstName,
+		IP:        event.IP,
+		Date:      time.Now(),
+		Until:     time.Now().Add(app.config.login.lockoutDuration),
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, app.models.LoginEvents.Insert(event, msg)
+}
@@ -3,30 +3,39 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
-	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"youneon-BE/internal/cache"
 	"youneon-BE/internal/data"
 	"youneon-BE/internal/data/mailer"
 	"youneon-BE/internal/jsonlog"
+	"youneon-BE/internal/keyring"
+	"youneon-BE/internal/oidc"
+	"youneon-BE/internal/payment"
+	"youneon-BE/internal/ratelimit"
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port    int
+	env     string
+	baseURL string
+	db      struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		autoMigrate  bool
 	}
 	smtp struct {
 		host     string
@@ -35,24 +44,134 @@ type config struct {
 		password string
 		sender   string
 	}
+	mail struct {
+		transport string
+		dir       string
+		debug     bool
+	}
 	cors struct {
 		trustedOrigins []string
 	}
+	cookie struct {
+		enabled  bool
+		name     string
+		domain   string
+		secure   bool
+		sameSite http.SameSite
+	}
 	jwt struct {
-		secret string
+		secret   string
+		keysDir  string
+		issuer   string
+		audience string
+		ttl      time.Duration
 	}
 	redis struct {
 		addr     string
 		password string
 		db       int
 	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	login struct {
+		window          time.Duration
+		maxFailures     int
+		lockoutDuration time.Duration
+		delayAfter      int
+		maxDelay        time.Duration
+		ipMaxFailures   int
+	}
+	cache struct {
+		store string
+		ttl   time.Duration
+		size  int
+	}
+	httpCache struct {
+		maxAge time.Duration
+	}
+	limiter struct {
+		enabled bool
+		store   string
+		global  struct {
+			rps   float64
+			burst int
+		}
+		ip struct {
+			rps   float64
+			burst int
+		}
+		user struct {
+			rps   float64
+			burst int
+		}
+		loginPerMinute  int
+		registerPerHour int
+		trustedProxies  []netip.Prefix
+	}
+	outbox struct {
+		workers      int
+		maxAttempts  int
+		pollInterval time.Duration
+		drainTimeout time.Duration
+	}
+	oidc struct {
+		resultURL string
+		providers []oidcProviderConfig
+	}
+	payment struct {
+		resultURL string
+		vnpay     struct {
+			tmnCode    string
+			hashSecret string
+			payURL     string
+		}
+		momo struct {
+			partnerCode string
+			accessKey   string
+			secretKey   string
+			endpoint    string
+		}
+		fake struct {
+			enabled bool
+			secret  string
+		}
+	}
 }
+
+// oidcProviderConfig configures one social login provider. Only the name, client id
+// and secret are required for the presets; any other provider needs its issuer, and
+// the endpoints when it doesn't publish a discovery document.
+type oidcProviderConfig struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	jwksURL      string
+	redirectURL  string
+	scopes       []string
+}
+
 type application struct {
 	config config
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
-	redis  *RedisLocal
+	// mailbox captures sent emails when the memory mail transport is used.
+	mailbox *mailer.MemoryTransport
+	redis   *RedisLocal
+	limiter *rateLimiter
+	// cache holds the catalogue reads of the models, nil when caching is off.
+	cache    *cache.Cache
+	payments payment.Registry
+	oidc     oidc.Registry
+	keys     *keyring.Keyring
+	// quit is closed on shutdown to stop the long running background loops, and wg
+	// tracks every goroutine started with background() so shutdown can wait for them.
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
 func main() {
@@ -65,7 +184,7 @@ func main() {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
 	if err != nil {
-		fmt.Printf("Invalid PORT value: %s\n", smtpPort)
+		fmt.Printf("Invalid PORT value: %s\n", os.Getenv("SMTP_PORT"))
 		smtpPort = 4000 // Use a default value if conversion fails
 	}
 	smtpUsername := os.Getenv("SMTP_USER")
@@ -81,21 +200,49 @@ func main() {
 	//go run ./cmd/api -help for more information
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.baseURL, "base-url", getEnv("BASE_URL", "http://localhost:4000"), "Public URL of the API, used in payment callbacks")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", dbDsn, "PostgreSQL DSN")
 	fmt.Printf("DB DSN: %s\n", cfg.db.dsn)
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.BoolVar(&cfg.db.autoMigrate, "db-automigrate", getEnv("DB_AUTOMIGRATE", "false") == "true", "Apply pending database migrations on startup")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", smtpHost, "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", smtpPort, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", smtpUsername, "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", smtpPassword, "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", smtpSender, "SMTP sender")
+	flag.StringVar(&cfg.mail.transport, "mail-transport", getEnv("MAIL_TRANSPORT", "smtp"), "Mail transport (smtp|file|memory)")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", getEnv("MAIL_DIR", "./tmp/mail"), "Directory the file mail transport writes .eml files to")
+	flag.BoolVar(&cfg.mail.debug, "mail-debug", os.Getenv("MAIL_DEBUG") == "true", "Serve the sent emails at /debug/mail without authentication, never enable it in production")
 
 	cfg.cors.trustedOrigins = strings.Fields(corsTrustOrigin)
 
-	flag.StringVar(&cfg.jwt.secret, "jwt-secret", jwtSecret, "JWT secret")
+	flag.BoolVar(&cfg.cookie.enabled, "auth-cookie", getEnv("AUTH_COOKIE", "false") == "true", "Let clients log in with an HttpOnly session cookie instead of a bearer token")
+	flag.StringVar(&cfg.cookie.name, "auth-cookie-name", getEnv("AUTH_COOKIE_NAME", "youneon_session"), "Name of the session cookie, the CSRF cookie gets a _csrf suffix")
+	flag.StringVar(&cfg.cookie.domain, "auth-cookie-domain", os.Getenv("AUTH_COOKIE_DOMAIN"), "Domain of the session cookies, empty for the API host only")
+	flag.BoolVar(&cfg.cookie.secure, "auth-cookie-secure", true, "Only send the session cookies over HTTPS")
+	cfg.cookie.sameSite = http.SameSiteLaxMode
+	flag.Func("auth-cookie-samesite", "SameSite attribute of the session cookies (lax|strict|none)", func(value string) error {
+		switch value {
+		case "lax":
+			cfg.cookie.sameSite = http.SameSiteLaxMode
+		case "strict":
+			cfg.cookie.sameSite = http.SameSiteStrictMode
+		case "none":
+			cfg.cookie.sameSite = http.SameSiteNoneMode
+		default:
+			return errors.New("must be lax, strict or none")
+		}
+		return nil
+	})
+
+	flag.StringVar(&cfg.jwt.secret, "jwt-secret", jwtSecret, "JWT secret, signs HS256 tokens while no key from -jwt-keys-dir is active and is retired once the first key has been active for -jwt-ttl")
+	flag.StringVar(&cfg.jwt.keysDir, "jwt-keys-dir", os.Getenv("JWT_KEYS_DIR"), "Directory with keys.json and the RS256/EdDSA signing keys")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", getEnv("JWT_ISSUER", "chatappbe.minhtc47.net"), "Issuer of the JWTs")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", getEnv("JWT_AUDIENCE", "chatappfe.minhtc47.net"), "Audience of the JWTs")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", 24*time.Hour, "Lifetime of authentication tokens, also how long keys overlap on rotation")
 
 	flag.StringVar(&cfg.redis.addr, "redis-addr", os.Getenv("REDIS_ADDR"), "Redis address")
 	flag.StringVar(&cfg.redis.password, "redis-password", os.Getenv("REDIS_PASSWORD"), "Redis password")
@@ -103,23 +250,124 @@ func main() {
 	if cfg.redis.db == -1 {
 		cfg.redis.db, err = strconv.Atoi(os.Getenv("REDIS_DB"))
 		if err != nil {
-			fmt.Printf("Invalid DB value: %s\n", cfg.redis.db)
+			fmt.Printf("Invalid DB value: %s\n", os.Getenv("REDIS_DB"))
 			cfg.redis.db = 0
 		}
 	}
+
+	flag.IntVar(&cfg.outbox.workers, "email-workers", 2, "Number of email outbox workers")
+	flag.IntVar(&cfg.outbox.maxAttempts, "email-max-attempts", 8, "Delivery attempts before an email is moved to the dead state")
+	flag.DurationVar(&cfg.outbox.pollInterval, "email-poll-interval", 5*time.Second, "How often the email outbox is polled")
+	flag.DurationVar(&cfg.outbox.drainTimeout, "email-drain-timeout", 20*time.Second, "How long to keep sending queued emails on shutdown")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept")
+
+	flag.DurationVar(&cfg.login.window, "login-window", 15*time.Minute, "How far back failed sign-in attempts are counted")
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 10, "Failed passwords in a row before the account is locked")
+	flag.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", 15*time.Minute, "How long an account stays locked")
+	flag.IntVar(&cfg.login.delayAfter, "login-delay-after", 3, "Failed passwords in a row before each attempt has to wait")
+	flag.DurationVar(&cfg.login.maxDelay, "login-max-delay", time.Minute, "Longest wait between two sign-in attempts before the lockout")
+	flag.IntVar(&cfg.login.ipMaxFailures, "login-ip-max-failures", 50, "Failed sign-ins from one IP address, any account, before it is blocked")
+
+	flag.StringVar(&cfg.cache.store, "cache-store", getEnv("CACHE_STORE", "memory"), "Catalogue cache store (none|memory|redis)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 5*time.Minute, "How long catalogue reads are cached")
+	flag.IntVar(&cfg.cache.size, "cache-size", 1000, "Entries kept by the memory catalogue cache")
+	flag.DurationVar(&cfg.httpCache.maxAge, "http-max-age", time.Minute, "How long browsers and CDNs may reuse anonymous catalogue responses")
+
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	flag.StringVar(&cfg.limiter.store, "limiter-store", getEnv("LIMITER_STORE", "memory"), "Rate limiter store (memory|redis)")
+	flag.Float64Var(&cfg.limiter.global.rps, "limiter-global-rps", 500, "Requests per second accepted from all clients together")
+	flag.IntVar(&cfg.limiter.global.burst, "limiter-global-burst", 1000, "Global rate limiter burst")
+	flag.Float64Var(&cfg.limiter.ip.rps, "limiter-ip-rps", 10, "Requests per second accepted from a single IP address")
+	flag.IntVar(&cfg.limiter.ip.burst, "limiter-ip-burst", 40, "Per-IP rate limiter burst")
+	flag.Float64Var(&cfg.limiter.user.rps, "limiter-user-rps", 5, "Requests per second accepted from a single authenticated user")
+	flag.IntVar(&cfg.limiter.user.burst, "limiter-user-burst", 20, "Per-user rate limiter burst")
+	flag.IntVar(&cfg.limiter.loginPerMinute, "limiter-login-per-minute", 5, "Login attempts per minute from a single IP address")
+	flag.IntVar(&cfg.limiter.registerPerHour, "limiter-register-per-hour", 10, "Registrations per hour from a single IP address")
+	flag.Func("trusted-proxies", "Space separated IPs or CIDR ranges of reverse proxies allowed to set X-Forwarded-For", func(value string) error {
+		var err error
+		cfg.limiter.trustedProxies, err = parseTrustedProxies(value)
+		return err
+	})
+	cfg.limiter.trustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		fmt.Printf("Invalid TRUSTED_PROXIES value: %s\n", os.Getenv("TRUSTED_PROXIES"))
+	}
+
+	var oidcProviders string
+	flag.StringVar(&oidcProviders, "oidc-providers", os.Getenv("OIDC_PROVIDERS"), "Space separated social login providers, configured with OIDC_<NAME>_* variables")
+	flag.StringVar(&cfg.oidc.resultURL, "oidc-result-url", os.Getenv("OIDC_RESULT_URL"), "FE page social logins redirect to, with the tokens in the URL fragment")
+
+	flag.StringVar(&cfg.payment.resultURL, "payment-result-url", os.Getenv("PAYMENT_RESULT_URL"), "FE page customers are redirected to after paying")
+	flag.StringVar(&cfg.payment.vnpay.tmnCode, "vnpay-tmn-code", os.Getenv("VNPAY_TMN_CODE"), "VNPay terminal code")
+	flag.StringVar(&cfg.payment.vnpay.hashSecret, "vnpay-hash-secret", os.Getenv("VNPAY_HASH_SECRET"), "VNPay hash secret")
+	flag.StringVar(&cfg.payment.vnpay.payURL, "vnpay-pay-url", getEnv("VNPAY_PAY_URL", "https://sandbox.vnpayment.vn/paymentv2/vpcpay.html"), "VNPay payment URL")
+	flag.StringVar(&cfg.payment.momo.partnerCode, "momo-partner-code", os.Getenv("MOMO_PARTNER_CODE"), "MoMo partner code")
+	flag.StringVar(&cfg.payment.momo.accessKey, "momo-access-key", os.Getenv("MOMO_ACCESS_KEY"), "MoMo access key")
+	flag.StringVar(&cfg.payment.momo.secretKey, "momo-secret-key", os.Getenv("MOMO_SECRET_KEY"), "MoMo secret key")
+	flag.StringVar(&cfg.payment.momo.endpoint, "momo-endpoint", getEnv("MOMO_ENDPOINT", "https://test-payment.momo.vn/v2/gateway/api/create"), "MoMo create payment endpoint")
+	flag.BoolVar(&cfg.payment.fake.enabled, "payment-fake", false, "Enable the offline fake payment provider, never in production")
+	flag.StringVar(&cfg.payment.fake.secret, "payment-fake-secret", getEnv("PAYMENT_FAKE_SECRET", "fake-secret"), "Signing secret of the fake payment provider")
 	flag.Parse()
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	if cfg.cookie.sameSite == http.SameSiteNoneMode && !cfg.cookie.secure {
+		fmt.Println("SameSite=None cookies must be Secure, enabling -auth-cookie-secure")
+		cfg.cookie.secure = true
+	}
+
+	if cfg.mail.debug && cfg.env == "production" {
+		fmt.Println("The mail viewer exposes every email sent, ignoring -mail-debug in production")
+		cfg.mail.debug = false
+	}
+	if cfg.payment.fake.enabled && cfg.env == "production" {
+		fmt.Println("The fake payment provider accepts callbacks signed with a known secret, ignoring -payment-fake in production")
+		cfg.payment.fake.enabled = false
+	}
 
-	//redisClient, err := openRedis(cfg)
-	//if err != nil {
-	//	logger.PrintFatal(err, nil)
-	//}
-	//defer redisClient.Close()
-	//logger.PrintInfo("Redis connection established", nil)
+	for _, name := range strings.Fields(oidcProviders) {
+		cfg.oidc.providers = append(cfg.oidc.providers, oidcProviderFromEnv(name, cfg.baseURL))
+	}
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
 	redisLocal := NewRedisLocal()
 
+	var limiterStore ratelimit.Store
+	var memoryLimiterStore *ratelimit.MemoryStore
+	switch cfg.limiter.store {
+	case "memory":
+		memoryLimiterStore = ratelimit.NewMemoryStore()
+		limiterStore = memoryLimiterStore
+	case "redis":
+		redisClient, err := openRedis(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer redisClient.Close()
+		logger.PrintInfo("Redis connection established", nil)
+		limiterStore = &ratelimit.RedisStore{Client: redisClient, Prefix: "ratelimit:"}
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown rate limiter store %q", cfg.limiter.store), nil)
+	}
+
+	// The seed and import-products commands use the cache too, so their changes
+	// invalidate what the API instances sharing a Redis cache have kept.
+	var catalogue *cache.Cache
+	switch cfg.cache.store {
+	case "none":
+	case "memory":
+		catalogue = cache.New(cache.NewMemoryStore(cfg.cache.size), "catalogue:", cfg.cache.ttl)
+	case "redis":
+		redisClient, err := openRedis(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer redisClient.Close()
+		catalogue = cache.New(&cache.RedisStore{Client: redisClient}, "catalogue:", cfg.cache.ttl)
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown cache store %q", cfg.cache.store), nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -127,35 +375,175 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	// go run ./cmd/api migrate up|down [N]|to VERSION|status
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(db, logger, os.Stdout, flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+	// go run ./cmd/api seed [-fixtures DIR] [-no-fixtures] [-products N]
+	if flag.Arg(0) == "seed" {
+		err = runSeed(data.NewModels(db, catalogue), logger, cfg.env, flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+	// go run ./cmd/api import-products [-dry-run] FILE
+	if flag.Arg(0) == "import-products" {
+		err = runImportProducts(data.NewModels(db, catalogue), os.Stdout, flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+	if cfg.db.autoMigrate {
+		err = autoMigrate(db, logger)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	var signingKeys []*keyring.Key
+	if cfg.jwt.keysDir != "" {
+		signingKeys, err = keyring.Load(cfg.jwt.keysDir)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+	if len(signingKeys) == 0 && cfg.jwt.secret == "" {
+		logger.PrintFatal(errors.New("either -jwt-keys-dir or -jwt-secret must be set"), nil)
+	}
+
+	var transport mailer.Transport
+	var mailbox *mailer.MemoryTransport
+	switch cfg.mail.transport {
+	case "smtp":
+		transport = mailer.NewSMTPTransport(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	case "file":
+		transport = &mailer.FileTransport{Dir: cfg.mail.dir}
+	case "memory":
+		mailbox = &mailer.MemoryTransport{Limit: 100}
+		transport = mailbox
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown mail transport %q", cfg.mail.transport), nil)
+	}
+
+	limiter, err := newRateLimiter(cfg, limiterStore)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(
-			cfg.smtp.host,
-			cfg.smtp.port,
-			cfg.smtp.username,
-			cfg.smtp.password,
-			cfg.smtp.sender),
-		redis: redisLocal,
-	}
-
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		ErrorLog:     log.New(logger, "", 0),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}
-	logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  cfg.env,
-	})
-	err = srv.ListenAndServe()
-	logger.PrintFatal(err, nil)
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db, catalogue),
+		mailer:   mailer.NewWithTransport(transport, cfg.smtp.sender),
+		mailbox:  mailbox,
+		redis:    redisLocal,
+		limiter:  limiter,
+		cache:    catalogue,
+		payments: newPaymentRegistry(cfg),
+		oidc:     newOIDCRegistry(cfg),
+		keys:     keyring.New(signingKeys, []byte(cfg.jwt.secret), cfg.jwt.ttl),
+		quit:     make(chan struct{}),
+	}
 
+	app.background(app.cleanupIdempotencyKeys)
+	if memoryLimiterStore != nil {
+		app.background(func() { app.sweepRateLimits(memoryLimiterStore) })
+	}
+	app.startOutboxWorkers()
+	if cfg.jwt.keysDir != "" {
+		app.background(app.reloadSigningKeys)
+	}
+
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func newPaymentRegistry(cfg config) payment.Registry {
+	registry := payment.Registry{}
+	registry.Register(payment.CashOnDelivery{})
+	if cfg.payment.vnpay.tmnCode != "" {
+		registry.Register(payment.VNPay{
+			TmnCode:    cfg.payment.vnpay.tmnCode,
+			HashSecret: cfg.payment.vnpay.hashSecret,
+			PayURL:     cfg.payment.vnpay.payURL,
+		})
+	}
+	if cfg.payment.momo.partnerCode != "" {
+		registry.Register(payment.MoMo{
+			PartnerCode: cfg.payment.momo.partnerCode,
+			AccessKey:   cfg.payment.momo.accessKey,
+			SecretKey:   cfg.payment.momo.secretKey,
+			Endpoint:    cfg.payment.momo.endpoint,
+		})
+	}
+	if cfg.payment.fake.enabled {
+		registry.Register(payment.Fake{Secret: cfg.payment.fake.secret, Succeed: true})
+	}
+	return registry
+}
+
+// oidcPresets holds the endpoints of the well known providers.
+var oidcPresets = map[string]oidcProviderConfig{
+	"google": {
+		issuer: "https://accounts.google.com",
+	},
+	"facebook": {
+		issuer:   "https://www.facebook.com",
+		authURL:  "https://www.facebook.com/v19.0/dialog/oauth",
+		tokenURL: "https://graph.facebook.com/v19.0/oauth/access_token",
+		jwksURL:  "https://limited.facebook.com/.well-known/oauth/openid/jwks/",
+	},
+}
+
+func oidcProviderFromEnv(name, baseURL string) oidcProviderConfig {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+	preset := oidcPresets[name]
+	return oidcProviderConfig{
+		name:         name,
+		issuer:       getEnv(prefix+"ISSUER", preset.issuer),
+		clientID:     os.Getenv(prefix + "CLIENT_ID"),
+		clientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		authURL:      getEnv(prefix+"AUTH_URL", preset.authURL),
+		tokenURL:     getEnv(prefix+"TOKEN_URL", preset.tokenURL),
+		jwksURL:      getEnv(prefix+"JWKS_URL", preset.jwksURL),
+		redirectURL:  getEnv(prefix+"REDIRECT_URL", baseURL+"/auth/"+name+"/callback"),
+		scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+	}
+}
+
+func newOIDCRegistry(cfg config) oidc.Registry {
+	registry := oidc.Registry{}
+	for _, p := range cfg.oidc.providers {
+		registry.Register(&oidc.Provider{
+			Name:         p.name,
+			Issuer:       p.issuer,
+			ClientID:     p.clientID,
+			ClientSecret: p.clientSecret,
+			RedirectURL:  p.redirectURL,
+			AuthURL:      p.authURL,
+			TokenURL:     p.tokenURL,
+			JWKSURL:      p.jwksURL,
+			Scopes:       p.scopes,
+		})
+	}
+	return registry
 }
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -180,19 +568,12 @@ func openDB(cfg config) (*sql.DB, error) {
 }
 
 func openRedis(cfg config) (*redis.Client, error) {
-	//opt := &redis.Options{
-	//	Addr:        cfg.redis.addr,
-	//	Password:    cfg.redis.password, // Leave empty for no password
-	//	DB:          cfg.redis.db,       // Default DB
-	//	DialTimeout: 10 * time.Second,
-	//	Username:    "default",
-	//}
-
 	opt := &redis.Options{
-		Addr:     "redis-15133.c252.ap-southeast-1-1.ec2.redns.redis-cloud.com:15133",
-		Username: "default",
-		Password: "1JMxgARJe3EDrZlRo7NDQBFsYN1C4JOT",
-		DB:       0,
+		Addr:        cfg.redis.addr,
+		Password:    cfg.redis.password, // Leave empty for no password
+		DB:          cfg.redis.db,       // Default DB
+		DialTimeout: 10 * time.Second,
+		Username:    "default",
 	}
 	client := redis.NewClient(opt)
 
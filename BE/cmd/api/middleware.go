@@ -1,46 +1,62 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/pascaldekloe/jwt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
 )
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "Cookie")
+		w.Header().Add("Vary", "X-API-Key")
 		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
-			next.ServeHTTP(w, r)
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" && authorizationHeader == "" {
+			app.authenticateAPIKey(w, r, next, apiKey)
 			return
 		}
-		// Otherwise, we expect the value of the Authorization header to be in the format
-		// "Bearer <token>". We try to split this into its constituent parts, and if the
-		// header isn't in the expected format we return a 401 Unauthorized response
-		// using the invalidAuthenticationTokenResponse() helper (which we will create
-		// in a moment).
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+		var token string
+		fromCookie := false
+		if authorizationHeader != "" {
+			// We expect the value of the Authorization header to be in the format
+			// "Bearer <token>". We try to split this into its constituent parts, and if the
+			// header isn't in the expected format we return a 401 Unauthorized response
+			// using the invalidAuthenticationTokenResponse() helper.
+			headerParts := strings.Split(authorizationHeader, " ")
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			// Extract the actual authentication token from the header parts.
+			token = headerParts[1]
+		} else if cookie, err := r.Cookie(app.config.cookie.name); err == nil && app.config.cookie.enabled {
+			token = cookie.Value
+			fromCookie = true
+		} else {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
 			return
 		}
-		// Extract the actual authentication token from the header parts.
-		token := headerParts[1]
 
 		if app.redis.isLogoutToken(token) {
 			app.badRequestResponse(w, r, errors.New("invalid token"))
 			return
 		}
 		// Parse the JWT and extract the claims. This will return an error if the JWT
-		// contents doesn't match the signature (i.e. the token has been tampered with)
-		// or the algorithm isn't valid.
-		claims, err := jwt.HMACCheck([]byte(token), []byte(app.config.jwt.secret))
+		// contents doesn't match the signature of any published key (i.e. the token
+		// has been tampered with) or the algorithm isn't valid.
+		claims, err := app.keys.Check([]byte(token))
 		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -52,15 +68,24 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Check that the issuer is our application.
-		if claims.Issuer != "chatappbe.minhtc47.net" {
+		if claims.Issuer != app.config.jwt.issuer {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 		// Check that our application is in the expected audiences for the JWT.
-		if !claims.AcceptAudience("chatappfe.minhtc47.net") {
+		if !claims.AcceptAudience(app.config.jwt.audience) {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
+		// The browser sends the session cookie whoever triggers the request, so
+		// state-changing requests have to prove they come from our FE.
+		if fromCookie && !isSafeMethod(r.Method) {
+			sessionHash, _ := claims.String("csrf")
+			if !app.validCSRF(r, sessionHash) {
+				app.invalidCSRFTokenResponse(w, r)
+				return
+			}
+		}
 		// At this point, we know that the JWT is all OK and we can trust the data in
 		// it. We extract the user ID from the claims subject and convert it from a
 		// string into an int64.
@@ -80,14 +105,70 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Tokens issued before the account was closed stay valid until they expire.
+		if user.IsDeleted() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
 
 		// Add the user record to the request context and continue as normal.
 		r = app.contextSetUser(r, user)
 		r = app.contextSetToken(r, token)
+		r = app.contextSetSecondFactor(r, hasAuthMethod(claims, "otp"))
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey authenticates a partner integration's request as the owner of
+// the key. Which routes the key reaches is up to requireScope().
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	key, err := app.models.APIKeys.GetByHash(data.HashAPIKey(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !key.Active() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Get(key.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.APIKeys.TouchLastUsed(key.Id)
+	if err != nil {
+		app.logError(r, err)
+	}
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
+// hasAuthMethod reports whether the amr claim of the token lists the method.
+func hasAuthMethod(claims *jwt.Claims, method string) bool {
+	amr, ok := claims.Set["amr"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, m := range amr {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
 // Create a new requireAuthenticatedUser() middleware to check that a user is not
 // anonymous.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
@@ -97,10 +178,51 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
+		// API keys only reach the routes which declare a scope with requireScope().
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// requireScope() lets authenticated users through, and requests made with an API
+// key when the key was granted the scope.
+func (app *application) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		if key := app.contextGetAPIKey(r); key != nil && !key.HasScope(scope) {
+			app.missingScopeResponse(w, r, scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin() checks that the user is authenticated and has the admin role.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if !user.IsAdmin() {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		// Admins have to sign in with their second factor. One who hasn't enrolled
+		// yet can still use the /user/2fa endpoints to do so.
+		if !app.contextGetSecondFactor(r) {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return app.requireAuthenticatedUser(fn)
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Origin" header.
@@ -120,9 +242,13 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					// response header with the request origin as the value and break
 					// out of the loop.
 					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS") // Allow methods
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")     //Allow tags in header
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")                               // Allow methods
+					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Idempotency-Key, "+csrfHeader) //Allow tags in header
+					w.Header().Set("Access-Control-Expose-Headers", "Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Idempotent-Replayed")
+					// Trusted origins may send the session cookie along, the wildcard origin
+					// is never used so browsers accept the credentialed responses.
 					w.Header().Set("Access-Control-Allow-Credentials", "true")
+					w.Header().Set("Access-Control-Max-Age", "600")
 					break
 				}
 			}
@@ -135,3 +261,114 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// responseRecorder passes the response through to the client while keeping a copy
+// of the status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// The idempotent() middleware makes a handler safe to retry when the client sends an
+// Idempotency-Key header. The first request with a key is processed normally and its
+// response stored; retries with the same key and body get the stored response back,
+// while reusing the key for a different body is rejected. It must run after
+// requireAuthenticatedUser() because keys are scoped per user.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+
+		user := app.contextGetUser(r)
+		record := &data.IdempotencyKey{
+			Key:           key,
+			UserId:        user.ID,
+			RequestMethod: r.Method,
+			RequestPath:   r.URL.Path,
+			RequestHash:   hex.EncodeToString(hash[:]),
+			ExpiresAt:     time.Now().Add(app.config.idempotency.ttl),
+		}
+		reserved, err := app.models.IdempotencyKeys.Reserve(record)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !reserved {
+			stored, err := app.models.IdempotencyKeys.Get(user.ID, key)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			switch {
+			case stored.RequestHash != record.RequestHash:
+				app.idempotencyKeyMismatchResponse(w, r)
+			case stored.StatusCode == nil:
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*stored.StatusCode)
+				w.Write(stored.ResponseBody)
+			}
+			return
+		}
+
+		// The key is released unless the response gets stored: server errors are not
+		// stored so the client can retry with the same key once the problem is fixed,
+		// and a panicking handler mustn't leave the key in progress until it expires.
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			err := app.models.IdempotencyKeys.Delete(user.ID, key)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.statusCode != 0 && rec.statusCode < http.StatusInternalServerError {
+			record.StatusCode = &rec.statusCode
+			record.ResponseBody = rec.body.Bytes()
+			err = app.models.IdempotencyKeys.SaveResponse(record)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			saved = true
+		}
+	})
+}
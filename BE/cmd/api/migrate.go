@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"youneon-BE/internal/jsonlog"
+	"youneon-BE/internal/migrate"
+	"youneon-BE/migrations"
+)
+
+const migrateUsage = `usage: api [flags] migrate up|down [N]|to VERSION|status`
+
+// runMigrate runs the migrate subcommand:
+//
+//	migrate up           apply all pending migrations
+//	migrate down [N]     revert the last N applied migrations, 1 by default
+//	migrate to VERSION   migrate up or down to VERSION, 0 reverts everything
+//	migrate status       list the migrations and when they were applied
+func runMigrate(db *sql.DB, logger *jsonlog.Logger, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var done []*migrate.Migration
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		done, err = m.Up(ctx)
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		done, err = m.Down(ctx, steps)
+	case args[0] == "to" && len(args) == 2:
+		version, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		done, err = m.To(ctx, version)
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%06d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+	for _, migration := range done {
+		logger.PrintInfo("migrated", map[string]string{"version": strconv.FormatInt(migration.Version, 10), "name": migration.Name})
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		logger.PrintInfo("no migrations to run", nil)
+	}
+	return nil
+}
+
+// autoMigrate applies the pending migrations on startup when -db-automigrate is set.
+func autoMigrate(db *sql.DB, logger *jsonlog.Logger) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	done, err := m.Up(context.Background())
+	for _, migration := range done {
+		logger.PrintInfo("migrated", map[string]string{"version": strconv.FormatInt(migration.Version, 10), "name": migration.Name})
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+	"youneon-BE/internal/data"
+)
+
+type orderEmailItem struct {
+	Name     string
+	Quantity int
+	Price    int
+	Subtotal int
+}
+
+type orderEmailData struct {
+	FirstName      string
+	OrderID        string
+	Status         string
+	Date           time.Time
+	AddressDetail  string
+	TrackingNumber string
+	Items          []orderEmailItem
+	Total          int
+	Amount         int
+	Provider       string
+}
+
+type accountLockedEmailData struct {
+	FirstName string
+	IP        string
+	Date      time.Time
+	Until     time.Time
+}
+
+// newOrderEmail builds one of the order lifecycle emails for the owner of the order.
+// The message is meant to be passed to the model method which makes the triggering
+// change, so it lands in the email outbox in the same transaction. When items is nil
+// the items are loaded from the database. The payment is only used by
+// payment_received.tmpl and may be nil. The message is nil when the customer has
+// closed their account since.
+func (app *application) newOrderEmail(templateFile string, order *data.OrderDetail, items []*data.OrderItem, p *data.Payment) (*data.EmailMessage, error) {
+	user, err := app.models.Users.Get(order.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDeleted() {
+		return nil, nil
+	}
+	if items == nil {
+		items, err = app.models.OrderItem.GetAllByOrderID(order.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	emailData := orderEmailData{
+		FirstName:     user.FirstName,
+		OrderID:       order.Id.String(),
+		Status:        order.Status,
+		Date:          time.Now(),
+		AddressDetail: order.AddressDetail,
+		Items:         []orderEmailItem{},
+		Total:         order.Total,
+	}
+	if order.TrackingNumber != nil {
+		emailData.TrackingNumber = *order.TrackingNumber
+	}
+	if p != nil {
+		emailData.Amount = p.Amount
+		emailData.Provider = p.Provider
+	}
+	for _, item := range items {
+		name := item.ProductID.String()
+		product, err := app.models.Products.Get(item.ProductID)
+		if err == nil {
+			name = product.Name
+		}
+		emailData.Items = append(emailData.Items, orderEmailItem{
+			Name:     name,
+			Quantity: item.Quantity,
+			Price:    item.Price,
+			Subtotal: item.Price * item.Quantity,
+		})
+	}
+	return data.NewEmailMessage(user.Email, user.Language, templateFile, emailData)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/oidc"
+)
+
+var (
+	errEmailNotVerified = errors.New("the identity provider has not verified this email address")
+	errAccountExists    = errors.New("an account with this email address already exists")
+	errIdentityInUse    = errors.New("the external identity is linked to another account")
+)
+
+// oidcLoginTTL is how long the user has to come back from the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie holds the hash of the state of the login the browser started.
+// The callback only accepts a state that the browser started, otherwise anyone
+// could send a victim to the callback of a login the attacker started, signing
+// them in as the attacker or linking their identity to the attacker's account.
+// The cookie isn't scoped to /auth, as the API may be served under a prefix.
+func (app *application) oidcStateCookie(state string) *http.Cookie {
+	return &http.Cookie{
+		Name:     app.config.cookie.name + "_oidc",
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.config.cookie.secure,
+		// The provider sends the browser back with a top-level GET, which Lax allows.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// checkOIDCState reports whether the state of the callback is the one the browser
+// started, and clears the cookie.
+func (app *application) checkOIDCState(w http.ResponseWriter, r *http.Request, state string) bool {
+	cookie, err := r.Cookie(app.config.cookie.name + "_oidc")
+	expired := app.oidcStateCookie("")
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+	return err == nil && state != "" &&
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(csrfHash(state))) == 1
+}
+
+// @Summary Start a social login
+// @Description Redirect the browser to the provider's sign-in page (authorization code flow with PKCE). The provider sends it back to /auth/{provider}/callback.
+// @Description Started by a signed in user, the login links the external identity to their account.
+// @Tags users
+// @Param provider path string true "provider, e.g. google"
+// @Success 302
+// @Router /auth/{provider} [get]
+func (app *application) oidcStartHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.oidc.Get(app.readStringParam(r, "provider"))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	login := &data.OIDCLogin{
+		State:        oidc.RandomString(),
+		Provider:     provider.Name,
+		Nonce:        oidc.RandomString(),
+		CodeVerifier: oidc.RandomString(),
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		login.UserId = &user.ID
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.OIDCLogins.Insert(login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	http.SetCookie(w, app.oidcStateCookie(csrfHash(login.State)))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// @Summary Social login callback
+// @Description Finish the social login: the ID token is verified, the external identity is linked to the user who started the login or to a new account, and our usual tokens are issued.
+// @Description The browser must carry the state cookie set by /auth/{provider}, a callback for a login started elsewhere is refused ("invalid_state").
+// @Description An account with the same email is never linked automatically, its owner has to sign in and start the social login to link it ("account_exists").
+// @Description When OIDC_RESULT_URL is set the browser is redirected there with the tokens, or the error, in the URL fragment.
+// @Tags users
+// @Param provider path string true "provider, e.g. google"
+// @Param code query string true "authorization code"
+// @Param state query string true "state"
+// @Success 200 {string} authentication_token
+// @Router /auth/{provider}/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.oidc.Get(app.readStringParam(r, "provider"))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	qs := r.URL.Query()
+	if !app.checkOIDCState(w, r, qs.Get("state")) {
+		app.oidcFailure(w, r, http.StatusBadRequest, "invalid_state")
+		return
+	}
+	if qs.Get("error") != "" {
+		// The user cancelled, or the provider refused the request.
+		app.oidcFailure(w, r, http.StatusUnauthorized, qs.Get("error"))
+		return
+	}
+
+	login, err := app.models.OIDCLogins.Consume(qs.Get("state"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oidcFailure(w, r, http.StatusBadRequest, "invalid_state")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if login.Provider != provider.Name {
+		app.oidcFailure(w, r, http.StatusBadRequest, "invalid_state")
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), qs.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		app.logError(r, err)
+		app.oidcFailure(w, r, http.StatusUnauthorized, "invalid_grant")
+		return
+	}
+	user, err := app.oidcUser(login, idToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, errEmailNotVerified):
+			app.oidcFailure(w, r, http.StatusForbidden, "email_not_verified")
+		case errors.Is(err, errAccountExists):
+			app.oidcFailure(w, r, http.StatusConflict, "account_exists")
+		case errors.Is(err, errIdentityInUse):
+			app.oidcFailure(w, r, http.StatusConflict, "identity_in_use")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	event := &data.LoginEvent{
+		UserId:    &user.ID,
+		Email:     user.Email,
+		IP:        app.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	// Social logins happen in the browser, so they get the session cookie whenever
+	// cookie authentication is enabled.
+	tokens, err := app.completeLogin(w, user, event, "oidc:"+provider.Name, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.config.oidc.resultURL != "" {
+		fragment := url.Values{}
+		for key, value := range tokens {
+			if s, ok := value.(string); ok {
+				fragment.Set(key, s)
+			} else {
+				fragment.Set(key, "true")
+			}
+		}
+		http.Redirect(w, r, app.config.oidc.resultURL+"#"+fragment.Encode(), http.StatusSeeOther)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, tokens, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oidcUser returns the user the external identity belongs to. An identity seen for
+// the first time is linked to the user who started the login while signed in.
+// Otherwise a new account is created, refused when the provider didn't verify the
+// email or an account already has it: local signups don't verify their email, so
+// linking to that account would let whoever registered it first keep a password
+// to the account of the provider's user.
+func (app *application) oidcUser(login *data.OIDCLogin, idToken *oidc.IDToken) (*data.User, error) {
+	user, err := app.models.UserIdentities.GetUser(login.Provider, idToken.Subject)
+	switch {
+	case err == nil:
+		if login.UserId != nil && *login.UserId != user.ID {
+			return nil, errIdentityInUse
+		}
+		return user, nil
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	identity := &data.UserIdentity{Provider: login.Provider, Subject: idToken.Subject, Email: idToken.Email}
+	if login.UserId != nil {
+		user, err = app.models.Users.Get(*login.UserId)
+		if err != nil {
+			return nil, err
+		}
+		identity.UserId = user.ID
+		return user, app.models.UserIdentities.Insert(identity)
+	}
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+	_, err = app.models.Users.GetByEmail(idToken.Email)
+	switch {
+	case err == nil:
+		return nil, errAccountExists
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	user = &data.User{
+		Email:     idToken.Email,
+		FirstName: idToken.GivenName,
+		LastName:  idToken.FamilyName,
+		Language:  "vi",
+	}
+	if user.FirstName == "" {
+		user.FirstName, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	// The account has no usable password until the user sets one.
+	err = user.Password.Set(oidc.RandomString())
+	if err != nil {
+		return nil, err
+	}
+	err = app.models.UserIdentities.InsertWithUser(user, identity)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// oidcFailure ends a social login with an error code, in the result page's
+// fragment when there is one.
+func (app *application) oidcFailure(w http.ResponseWriter, r *http.Request, status int, code string) {
+	if app.config.oidc.resultURL != "" {
+		http.Redirect(w, r, app.config.oidc.resultURL+"#"+url.Values{"error": {code}}.Encode(), http.StatusSeeOther)
+		return
+	}
+	app.errorResponse(w, r, status, code)
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
+	"github.com/google/uuid"
 	"net/http"
 	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
 )
 
+// OrderRequest is the checkout of the cart. The total is computed from the prices
+// of the products, Total is only checked against it when the client sends one.
 type OrderRequest struct {
 	Total         int    `json:"total"`
 	AddressDetail string `json:"address_detail"`
@@ -33,39 +38,150 @@ func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	newOrderDetail := &data.OrderDetail{
-		Total:         input.Total,
 		AddressDetail: input.AddressDetail,
 		UserId:        user.ID,
-		Status:        "pending",
-	}
-	newOrderId, err := app.models.OrderDetail.Insert(newOrderDetail)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		Status:        data.OrderStatusPending,
 	}
 	cartItems, err := app.models.CartItems.GetAllByUserID(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	items := make([]*data.OrderItem, 0, len(cartItems))
 	for _, cartItem := range cartItems {
-		newOrderItem := &data.OrderItem{
+		product, err := app.models.Products.Get(cartItem.ProductId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		items = append(items, &data.OrderItem{
 			ProductID: cartItem.ProductId,
-			OrderID:   *newOrderId,
 			Quantity:  cartItem.Quantity,
+			Price:     product.Price,
+		})
+		newOrderDetail.Total += product.Price * cartItem.Quantity
+	}
+	v := validator.New()
+	v.Check(len(items) > 0, "cart", "must not be empty")
+	v.Check(input.Total == 0 || input.Total == newOrderDetail.Total, "total", "does not match the prices in the cart")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	newOrderDetail.Id = uuid.New()
+	confirmation, err := app.newOrderEmail("order_confirmation.tmpl", newOrderDetail, items, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.OrderDetail.Create(newOrderDetail, items, confirmation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"order": newOrderDetail}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type OrderStatusRequest struct {
+	Status         string  `json:"status"`
+	TrackingNumber *string `json:"tracking_number"`
+}
+
+// orderStatusEmails maps the statuses set by admins to the email sent to the customer.
+var orderStatusEmails = map[string]string{
+	data.OrderStatusShipped:   "order_shipped.tmpl",
+	data.OrderStatusDelivered: "order_delivered.tmpl",
+	data.OrderStatusCancelled: "order_cancelled.tmpl",
+}
+
+// @Summary Update the status of an order (admin)
+// @Description Move an order to "shipped" (with an optional tracking number), "delivered" or "cancelled" and notify the customer by email
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID"
+// @Param input body OrderStatusRequest true "Status"
+// @Success 200 {object} data.OrderDetail
+// @Security ApiKeyAuth
+// @Router /admin/orders/{id}/status [put]
+func (app *application) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var input OrderStatusRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	order, err := app.models.OrderDetail.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-		_, err := app.models.OrderItem.Insert(newOrderItem)
-		if err != nil {
+		return
+	}
+	templateFile, ok := orderStatusEmails[input.Status]
+	v := validator.New()
+	v.Check(ok, "status", "invalid status value")
+	v.Check(order.CanTransitionTo(input.Status), "status", "is not allowed from "+order.Status)
+	if input.TrackingNumber != nil {
+		v.Check(input.Status == data.OrderStatusShipped, "tracking_number", "can only be set when shipping")
+		v.Check(len(*input.TrackingNumber) <= 100, "tracking_number", "must not be more than 100 bytes long")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	fromStatus := order.Status
+	order.Status = input.Status
+	if input.TrackingNumber != nil {
+		order.TrackingNumber = input.TrackingNumber
+	}
+	notification, err := app.newOrderEmail(templateFile, order, nil, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.OrderDetail.Update(order, fromStatus, notification)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
-		err = app.models.CartItems.Delete(user.ID, cartItem.ProductId)
+		return
+	}
+	// Cash on delivery is collected by the courier, so delivery settles the payment.
+	if order.Status == data.OrderStatusDelivered {
+		payments, err := app.models.Payments.GetAllByOrderID(order.Id)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
+		for _, p := range payments {
+			if p.Provider == "cod" && p.Status == data.PaymentStatusPending {
+				p.Status = data.PaymentStatusSucceeded
+				err = app.models.Payments.Finalize(p, "")
+				if err != nil && !errors.Is(err, data.ErrEditConflict) {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+		}
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"order": newOrderDetail}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
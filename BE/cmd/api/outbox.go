@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+	"youneon-BE/internal/data"
+)
+
+// startOutboxWorkers launches the workers which deliver the email outbox. They
+// poll for due messages until app.quit is closed, then keep sending whatever is
+// already due for up to drainTimeout before exiting.
+func (app *application) startOutboxWorkers() {
+	for i := 0; i < app.config.outbox.workers; i++ {
+		app.background(app.outboxWorker)
+	}
+}
+
+func (app *application) outboxWorker() {
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.quit:
+			deadline := time.Now().Add(app.config.outbox.drainTimeout)
+			for time.Now().Before(deadline) && app.processOutboxBatch() > 0 {
+			}
+			return
+		case <-ticker.C:
+			for app.processOutboxBatch() > 0 {
+			}
+		}
+	}
+}
+
+// processOutboxBatch claims and sends a batch of due messages and returns how many
+// were claimed.
+func (app *application) processOutboxBatch() int {
+	messages, err := app.models.EmailOutbox.Claim(10, 2*time.Minute)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return 0
+	}
+	for _, msg := range messages {
+		err := app.deliverEmail(msg)
+		if err == nil {
+			err = app.models.EmailOutbox.MarkSent(msg.Id)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"email_id": msg.Id.String()})
+			}
+			continue
+		}
+
+		dead := msg.Attempts >= app.config.outbox.maxAttempts
+		app.logger.PrintError(err, map[string]string{
+			"email_id": msg.Id.String(),
+			"template": msg.Template,
+			"attempts": strconv.Itoa(msg.Attempts),
+		})
+		err = app.models.EmailOutbox.MarkFailed(msg.Id, err, time.Now().Add(outboxBackoff(msg.Attempts)), dead)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"email_id": msg.Id.String()})
+		}
+	}
+	return len(messages)
+}
+
+func (app *application) deliverEmail(msg *data.EmailMessage) error {
+	// Decode numbers as json.Number so templates print "1500000" rather than the
+	// float64 formatting "1.5e+06".
+	var templateData map[string]any
+	dec := json.NewDecoder(bytes.NewReader(msg.Data))
+	dec.UseNumber()
+	err := dec.Decode(&templateData)
+	if err != nil {
+		return err
+	}
+	return app.mailer.Send(msg.Recipient, msg.Locale, msg.Template, templateData)
+}
+
+// outboxBackoff doubles the delay after every failed attempt, starting at 30
+// seconds and capped at 6 hours.
+func outboxBackoff(attempts int) time.Duration {
+	delay := 30 * time.Second << min(max(attempts-1, 0), 10)
+	return min(delay, 6*time.Hour)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/payment"
+	"youneon-BE/internal/validator"
+)
+
+type PaymentRequest struct {
+	Provider string `json:"provider"`
+}
+
+// @Summary Pay for an order
+// @Description Start a payment attempt for an order with the given provider (vnpay, momo, cod). The response contains the URL the customer must be redirected to; it is empty for cash on delivery.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID"
+// @Param input body PaymentRequest true "Payment request"
+// @Success 201 {object} envelope
+// @Security ApiKeyAuth
+// @Router /orders/{id}/payments [post]
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	orderId, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input PaymentRequest
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	order, err := app.models.OrderDetail.GetById(orderId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if order.UserId != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+	v := validator.New()
+	provider, err := app.payments.Get(input.Provider)
+	v.Check(err == nil, "provider", "is not supported")
+	v.Check(order.Status == data.OrderStatusPending, "order", "is not awaiting payment")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	newPayment := &data.Payment{
+		OrderId:  order.Id,
+		Provider: provider.Name(),
+		Amount:   order.Total,
+		Status:   data.PaymentStatusPending,
+		TxnRef:   payment.NewTxnRef(),
+	}
+	err = app.models.Payments.Insert(newPayment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	checkout, err := provider.CreatePayment(r.Context(), payment.Request{
+		TxnRef:    newPayment.TxnRef,
+		OrderID:   order.Id.String(),
+		Amount:    newPayment.Amount,
+		OrderInfo: "Thanh toan don hang " + order.Id.String(),
+		ClientIP:  app.clientIP(r),
+		ReturnURL: app.config.baseURL + "/payments/" + provider.Name() + "/return",
+		IPNURL:    app.config.baseURL + "/payments/" + provider.Name() + "/ipn",
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Providers without a redirect (cash on delivery) confirm the order right away;
+	// the payment itself stays pending until the money is collected.
+	if checkout.RedirectURL == "" {
+		order.Status = data.OrderStatusConfirmed
+		err = app.models.OrderDetail.Update(order, data.OrderStatusPending)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"payment": newPayment, "payment_url": checkout.RedirectURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary List payments of an order
+// @Description List all payment attempts of an order
+// @Tags payments
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /orders/{id}/payments [get]
+func (app *application) listOrderPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	orderId, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	order, err := app.models.OrderDetail.GetById(orderId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if order.UserId != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+	payments, err := app.models.Payments.GetAllByOrderID(order.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payments": payments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Payment return URL
+// @Description The gateway redirects the customer here after paying
+// @Tags payments
+// @Produce json
+// @Param provider path string true "Provider"
+// @Success 200 {object} envelope
+// @Router /payments/{provider}/return [get]
+func (app *application) paymentReturnHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.payments.Get(app.readStringParam(r, "provider"))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	p, err := app.applyPaymentCallback(provider, r)
+	if err != nil && !errors.Is(err, payment.ErrAlreadyProcessed) {
+		switch {
+		case errors.Is(err, payment.ErrPaymentNotFound), errors.Is(err, payment.ErrCallbackNotSupported):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, payment.ErrInvalidSignature), errors.Is(err, payment.ErrAmountMismatch):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.config.payment.resultURL != "" {
+		qs := url.Values{}
+		qs.Set("order_id", p.OrderId.String())
+		qs.Set("status", p.Status)
+		http.Redirect(w, r, app.config.payment.resultURL+"?"+qs.Encode(), http.StatusSeeOther)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payment": p}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Payment IPN
+// @Description Server to server payment notification sent by the gateway
+// @Tags payments
+// @Produce json
+// @Param provider path string true "Provider"
+// @Success 200 {object} envelope
+// @Router /payments/{provider}/ipn [post]
+func (app *application) paymentIPNHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.payments.Get(app.readStringParam(r, "provider"))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.applyPaymentCallback(provider, r)
+	if err != nil && !errors.Is(err, payment.ErrAlreadyProcessed) {
+		app.logError(r, err)
+	}
+	status, body := provider.IPNResponse(err)
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	env := envelope{}
+	for key, value := range body {
+		env[key] = value
+	}
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyPaymentCallback verifies a gateway callback and records its outcome. The
+// return URL and the IPN usually both arrive for the same attempt, so the second one
+// gets payment.ErrAlreadyProcessed together with the stored payment.
+func (app *application) applyPaymentCallback(provider payment.PaymentProvider, r *http.Request) (*data.Payment, error) {
+	callback, err := provider.ParseCallback(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := app.models.Payments.GetByTxnRef(callback.TxnRef)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, payment.ErrPaymentNotFound
+		default:
+			return nil, err
+		}
+	}
+	if p.Provider != provider.Name() {
+		return nil, payment.ErrPaymentNotFound
+	}
+	if p.Amount != callback.Amount {
+		return nil, payment.ErrAmountMismatch
+	}
+	if p.Status != data.PaymentStatusPending {
+		return p, payment.ErrAlreadyProcessed
+	}
+
+	orderStatus := ""
+	var messages []*data.EmailMessage
+	p.Status = data.PaymentStatusFailed
+	if callback.Success {
+		p.Status = data.PaymentStatusSucceeded
+		orderStatus = data.OrderStatusPaid
+		order, err := app.models.OrderDetail.GetById(p.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		receipt, err := app.newOrderEmail("payment_received.tmpl", order, nil, p)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, receipt)
+	}
+	if callback.ProviderTxnID != "" {
+		p.ProviderTxnId = &callback.ProviderTxnID
+	}
+	err = app.models.Payments.Finalize(p, orderStatus, messages...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			p, err = app.models.Payments.GetByTxnRef(callback.TxnRef)
+			if err != nil {
+				return nil, err
+			}
+			return p, payment.ErrAlreadyProcessed
+		default:
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -3,51 +3,61 @@ package main
 import (
 	"errors"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 	"youneon-BE/internal/data"
 	"youneon-BE/internal/validator"
 )
 
 type ListProductsRequest struct {
-	Category  string
-	Tags      []string
-	Name      string
-	PriceFrom int
-	PriceTo   int
+	data.ProductFilter
 	data.Filters
 }
 
+// readListProductsRequest reads the filters of the product list from the query string.
+func (app *application) readListProductsRequest(qs url.Values, v *validator.Validator) ListProductsRequest {
+	var input ListProductsRequest
+	input.Category = app.readString(qs, "category", "")
+	input.Tags = app.readCSV(qs, "tags", []string{})
+	input.Name = app.readString(qs, "name", "")
+	input.PriceFrom = app.readInt(qs, "price_from", 0, v)
+	input.PriceTo = app.readInt(qs, "price_to", 900000000, v)
+	input.Availability = app.readString(qs, "availability", "")
+	v.Check(validator.PermittedValue(input.Availability, "", data.AvailabilityInStock, data.AvailabilityOutOfStock), "availability", "must be in_stock or out_of_stock")
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Cursor = app.readString(qs, "cursor", "")
+	input.Sort = app.readString(qs, "sort", "name")
+	input.SortSafelist = []string{"name", "price", "modified_at", "-name", "-price", "-modified_at", "relevance"}
+	return input
+}
+
 // @Summary List products
-// @Description Get a list of products, sorted by "name", "price", "modified_at", "-name", "-price", "-modified_at"
+// @Description Get a list of products, sorted by "name", "price", "modified_at", "-name", "-price", "-modified_at" or "relevance".
+// @Description The name search ignores accents, tolerates typos and also looks at the tags and description; "relevance" ranks its results.
+// @Description Pages can be fetched by number or, faster for deep pages, with the cursors in the metadata; cursors don't work with "relevance".
+// @Description The facets count the products by category, tag, price range and availability, applying every filter but their own.
 // @Tags products
 // @Accept json
 // @Produce json
-// @Param category query string false "Category"
+// @Param category query string false "Category slug, includes its subcategories"
 // @Param tags query []string false "Tags"
-// @Param name query string false "Name"
+// @Param name query string false "Search"
 // @Param price_from query int false "Price from"
 // @Param price_to query int false "Price to"
+// @Param availability query string false "in_stock or out_of_stock"
 // @Param page query int false "Page"
 // @Param page_size query int false "Page size"
+// @Param cursor query string false "next_cursor or prev_cursor of a previous page, instead of page"
 // @Param sort query string false "Sort"
 // @Success 200 {object} envelope
 // @Router /products [get]
 func (app *application) listProductHandler(w http.ResponseWriter, r *http.Request) {
-	var input ListProductsRequest
-
 	v := validator.New()
-	qs := r.URL.Query()
-
-	// Read query parameters
-	input.Category = app.readString(qs, "category", "")
-	input.Tags = app.readCSV(qs, "tags", []string{})
-	input.Name = app.readString(qs, "name", "")
-	input.PriceFrom = app.readInt(qs, "price_from", 0, v)
-	input.PriceTo = app.readInt(qs, "price_to", 900000000, v)
-
-	input.Page = app.readInt(qs, "page", 1, v)
-	input.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Sort = app.readString(qs, "sort", "name")
-	input.SortSafelist = []string{"name", "price", "modified_at", "-name", "-price", "-modified_at"}
+	input := app.readListProductsRequest(r.URL.Query(), v)
 
 	// Validate filters
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -56,24 +66,65 @@ func (app *application) listProductHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Call the GetAll method on the ProductModel
-	products, metadata, err := app.models.Products.GetAll(
-		input.Filters,
-		input.Category,
-		input.Tags,
-		input.Name,
-		input.PriceFrom,
-		input.PriceTo,
-	)
+	products, metadata, err := app.models.Products.GetAll(input.Filters, input.ProductFilter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	facets, err := app.models.Products.Facets(input.ProductFilter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Write the JSON response
-	err = app.writeJSON(w, http.StatusOK, envelope{
+	err = app.writeCatalogueJSON(w, r, envelope{
 		"products": products,
 		"metadata": metadata,
-	}, nil)
+		"facets":   facets,
+	}, time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getProductOrSuggestionsHandler serves GET /products/:id. httprouter doesn't
+// allow /products/suggest next to it, so the suggestions are routed through here.
+func (app *application) getProductOrSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.readStringParam(r, "id") == "suggest" {
+		app.suggestProductsHandler(w, r)
+		return
+	}
+	app.getProductHandler(w, r)
+}
+
+// @Summary Suggest products
+// @Description Suggest products for the search box, by name ignoring accents and typos
+// @Tags products
+// @Produce json
+// @Param q query string true "Search"
+// @Param limit query int false "Number of suggestions, 8 by default and 20 at most"
+// @Success 200 {object} envelope
+// @Router /products/suggest [get]
+func (app *application) suggestProductsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+	search := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 8, v)
+	v.Check(search != "", "q", "must be provided")
+	v.Check(utf8.RuneCountInString(search) <= 100, "q", "must not be more than 100 characters long")
+	v.Check(limit > 0 && limit <= 20, "limit", "must be between 1 and 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Products.Suggest(search, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeCatalogueJSON(w, r, envelope{"suggestions": suggestions}, time.Time{})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -105,14 +156,14 @@ func (app *application) getProductHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	err = app.writeCatalogueJSON(w, r, envelope{"product": product}, product.ModifiedAt)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 // @Summary Get all categories
-// @Description Get all categories
+// @Description Get the category tree, each level ordered by sort order and name
 // @Tags products
 // @Accept json
 // @Produce json
@@ -124,12 +175,8 @@ func (app *application) getAllCategories(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	listCategories := make([]string, len(categories))
-	for i, category := range categories {
-		listCategories[i] = category.Name
-	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"categories": listCategories}, nil)
+	err = app.writeCatalogueJSON(w, r, envelope{"categories": data.CategoryTree(categories)}, time.Time{})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -153,7 +200,7 @@ func (app *application) getAllTags(w http.ResponseWriter, r *http.Request) {
 		listTags[i] = tag.Name
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"tags": listTags}, nil)
+	err = app.writeCatalogueJSON(w, r, envelope{"tags": listTags}, time.Time{})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
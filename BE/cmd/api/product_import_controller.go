@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+// maxImportFileSize is the largest product file POST /admin/products/import accepts.
+const maxImportFileSize = 10 << 20
+
+// @Summary Import products
+// @Description Create or update products from a CSV or XLSX file, matched by SKU, in one transaction. The header row names the columns: sku, name and price are required, category (an existing category name), tags and images ("|" separated, the first image is the main one) and description are optional. Nothing is saved when a row has errors; the report lists the errors of each row. dry_run=true only validates the file.
+// @Tags admin
+// @Accept multipart/form-data
+// @Produce json
+// @Security ApiKeyAuth
+// @Param file formData file true "CSV or XLSX file"
+// @Param dry_run query bool false "Only validate the file"
+// @Success 200 {object} importReport
+// @Failure 422 {object} importReport
+// @Router /admin/products/import [post]
+func (app *application) importProductsHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := strconv.ParseBool(app.readString(r.URL.Query(), "dry_run", "false"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("dry_run must be true or false"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize)
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("the request must be multipart/form-data with a file field of at most 10MB"))
+		return
+	}
+	defer file.Close()
+	rows, err := readProductFile(fileHeader.Filename, file)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	report, err := importProducts(app.models, rows, dryRun)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if report.Failed > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	err = app.writeJSON(w, status, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Export products
+// @Description Download the products matching the filters of GET /products as CSV, in the format POST /admin/products/import reads. The rows are streamed a page at a time, so the file can be large.
+// @Tags admin
+// @Produce text/csv
+// @Security ApiKeyAuth
+// @Param category query string false "Category slug, includes its subcategories"
+// @Param tags query []string false "Tags"
+// @Param name query string false "Name"
+// @Param price_from query int false "Price from"
+// @Param price_to query int false "Price to"
+// @Param sort query string false "Sort"
+// @Success 200 {string} string
+// @Router /admin/products/export [get]
+func (app *application) exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	input := app.readListProductsRequest(r.URL.Query(), v)
+	input.Page = 1
+	input.Cursor = ""
+	input.PageSize = 100
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	categories, err := app.models.Categories.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	categoryNames := map[string]string{}
+	for _, c := range categories {
+		categoryNames[c.ID.String()] = c.Name
+	}
+	// Fetch the first page before writing anything, so an error can still be
+	// reported with a proper status code.
+	products, metadata, err := app.models.Products.GetAll(input.Filters, input.ProductFilter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="youneon-products-`+time.Now().Format("2006-01-02")+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	cw.Write(productColumns)
+	for {
+		for _, p := range products {
+			cw.Write(productRecord(p, categoryNames))
+		}
+		cw.Flush()
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if cw.Error() != nil {
+			break
+		}
+		// Follow the cursors, which stay fast deep into the catalogue, and fall
+		// back to page numbers for sorts without them.
+		if metadata.NextCursor != "" {
+			input.Cursor = metadata.NextCursor
+		} else if input.Cursor == "" && input.Page < metadata.LastPage {
+			input.Page++
+		} else {
+			break
+		}
+		products, metadata, err = app.models.Products.GetAll(input.Filters, input.ProductFilter)
+		if err != nil {
+			// The status line is gone already, all we can do is log and cut the file short.
+			app.logError(r, err)
+			return
+		}
+	}
+	if cw.Error() != nil {
+		app.logError(r, cw.Error())
+	}
+}
+
+// productRecord is the CSV row of a product, in the order of productColumns.
+func productRecord(p *data.Product, categoryNames map[string]string) []string {
+	record := make([]string, 0, len(productColumns))
+	var sku, description string
+	if p.Sku != nil {
+		sku = *p.Sku
+	}
+	if p.Description != nil {
+		description = *p.Description
+	}
+	var images []string
+	if p.Image != nil {
+		images = append(images, *p.Image)
+	}
+	if p.ImageList != nil {
+		images = append(images, *p.ImageList...)
+	}
+	record = append(record,
+		sku,
+		p.Name,
+		strconv.Itoa(p.Price),
+		categoryNames[p.CategoryId.String()],
+		strings.Join(p.Tags, productListSeparator),
+		strings.Join(images, productListSeparator),
+		description,
+	)
+	return record
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"youneon-BE/internal/ratelimit"
+)
+
+// rateLimiter holds the store and the policies applied by the rate limiting
+// middlewares. The global and per-IP buckets are checked for every request, the
+// per-user bucket once the request is authenticated, and the route policies only
+// on the routes which are worth brute forcing.
+type rateLimiter struct {
+	store    ratelimit.Store
+	global   ratelimit.Policy
+	ip       ratelimit.Policy
+	user     ratelimit.Policy
+	login    ratelimit.Policy
+	register ratelimit.Policy
+}
+
+// newRateLimiter builds the policies from the configuration, failing on one
+// which can't be enforced unless rate limiting is disabled.
+func newRateLimiter(cfg config, store ratelimit.Store) (*rateLimiter, error) {
+	limiter := &rateLimiter{
+		store:    store,
+		global:   ratelimit.Policy{Name: "global", Rate: cfg.limiter.global.rps, Burst: cfg.limiter.global.burst},
+		ip:       ratelimit.Policy{Name: "ip", Rate: cfg.limiter.ip.rps, Burst: cfg.limiter.ip.burst},
+		user:     ratelimit.Policy{Name: "user", Rate: cfg.limiter.user.rps, Burst: cfg.limiter.user.burst},
+		login:    ratelimit.PerMinute("login", cfg.limiter.loginPerMinute),
+		register: ratelimit.PerHour("register", cfg.limiter.registerPerHour),
+	}
+	if !cfg.limiter.enabled {
+		return limiter, nil
+	}
+	for _, p := range []ratelimit.Policy{limiter.global, limiter.ip, limiter.user, limiter.login, limiter.register} {
+		err := p.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return limiter, nil
+}
+
+// rateLimit applies the global and per-IP limits. It sits in front of authenticate
+// so that requests with bogus tokens are limited too.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.takeToken(w, r, app.limiter.global, "all") {
+			return
+		}
+		if !app.takeToken(w, r, app.limiter.ip, app.clientIP(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitUser applies the per-user limit to authenticated requests. It must run
+// after authenticate.
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if !user.IsAnonymous() && !app.takeToken(w, r, app.limiter.user, user.ID.String()) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitRoute applies a stricter policy, keyed by client IP, to a single route.
+func (app *application) rateLimitRoute(p ratelimit.Policy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.takeToken(w, r, p, app.clientIP(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// takeToken takes a token from the bucket and reports whether the request may go
+// on. When it may not, the 429 response has already been sent. A failing store
+// (Redis being down) lets the request through rather than taking the API down.
+func (app *application) takeToken(w http.ResponseWriter, r *http.Request, p ratelimit.Policy, key string) bool {
+	if !app.config.limiter.enabled {
+		return true
+	}
+	res, err := app.limiter.store.Take(r.Context(), p, key)
+	if err != nil {
+		app.logError(r, err)
+		return true
+	}
+
+	// Several buckets apply to a request, the headers describe the one closest to
+	// running out.
+	remaining, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining"))
+	if err != nil || res.Remaining <= remaining || !res.Allowed {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	}
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// sweepRateLimits forgets idle in-memory buckets. It is meant to be started with
+// background() and runs until app.quit is closed.
+func (app *application) sweepRateLimits(store *ratelimit.MemoryStore) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.quit:
+			return
+		case <-ticker.C:
+			store.Sweep(10 * time.Minute)
+		}
+	}
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only believed when
+// the request comes from a trusted proxy, and then the right-most address which
+// isn't one of our proxies is the client: everything left of it may be forged.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !app.isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		if !app.isTrustedProxy(ip) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+func (app *application) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range app.config.limiter.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a space separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Fields(value) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+type ReturnRequestInput struct {
+	OrderItemId string   `json:"order_item_id"`
+	Quantity    int      `json:"quantity"`
+	Reason      string   `json:"reason"`
+	Description string   `json:"description"`
+	Photos      []string `json:"photos"`
+}
+
+// @Summary Open a return
+// @Description Request the return of an item of a delivered order. Reason is one of "damaged", "wrong_item", "not_as_described", "changed_mind", "other"; photos are image URLs.
+// @Tags returns
+// @Accept json
+// @Produce json
+// @Param input body ReturnRequestInput true "Return request"
+// @Success 201 {object} envelope
+// @Security ApiKeyAuth
+// @Router /returns [post]
+func (app *application) createReturnHandler(w http.ResponseWriter, r *http.Request) {
+	var input ReturnRequestInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	v := validator.New()
+	orderItemId, err := uuid.Parse(input.OrderItemId)
+	if err != nil {
+		v.AddError("order_item_id", "must be a valid id")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	item, err := app.models.OrderItem.GetById(orderItemId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	order, err := app.models.OrderDetail.GetById(item.OrderID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if order.UserId != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+	ret := &data.ReturnRequest{
+		OrderId:     order.Id,
+		OrderItemId: item.ID,
+		UserId:      user.ID,
+		Quantity:    input.Quantity,
+		Reason:      input.Reason,
+		Description: input.Description,
+		Photos:      input.Photos,
+		Status:      data.ReturnStatusRequested,
+	}
+	if ret.Photos == nil {
+		ret.Photos = []string{}
+	}
+	v.Check(order.Status == data.OrderStatusDelivered, "order_item_id", "order has not been delivered")
+	if data.ValidateReturnRequest(v, ret); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.ReturnRequests.Insert(ret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrReturnQuantity):
+			v.AddError("quantity", "must not be more than the quantity left to return")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"return": ret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary List my returns
+// @Description List the return requests of the current user
+// @Tags returns
+// @Produce json
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /returns [get]
+func (app *application) listReturnsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	returns, err := app.models.ReturnRequests.GetAllByUserID(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"returns": returns}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Get a return
+// @Description Get a return request and its refunds. Admins can read any return.
+// @Tags returns
+// @Produce json
+// @Param id path string true "Return ID"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /returns/{id} [get]
+func (app *application) getReturnHandler(w http.ResponseWriter, r *http.Request) {
+	ret, ok := app.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	user := app.contextGetUser(r)
+	if ret.UserId != user.ID && !user.IsAdmin() {
+		app.notFoundResponse(w, r)
+		return
+	}
+	orderRefunds, err := app.models.Refunds.GetAllByOrderID(ret.OrderId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	refunds := []*data.Refund{}
+	for _, refund := range orderRefunds {
+		if refund.ReturnRequestId != nil && *refund.ReturnRequestId == ret.Id {
+			refunds = append(refunds, refund)
+		}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"return": ret, "refunds": refunds}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary List returns (admin)
+// @Description List return requests, optionally filtered by status ("requested", "approved", "rejected")
+// @Tags admin
+// @Produce json
+// @Param status query string false "Status"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/returns [get]
+func (app *application) adminListReturnsHandler(w http.ResponseWriter, r *http.Request) {
+	status := app.readString(r.URL.Query(), "status", "")
+	returns, err := app.models.ReturnRequests.GetAll(status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"returns": returns}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type ApproveReturnRequest struct {
+	RefundAmount *int    `json:"refund_amount"`
+	Note         *string `json:"note"`
+}
+
+// @Summary Approve a return (admin)
+// @Description Approve a return request, restock the returned units and record a refund. The refund defaults to the full price of the returned units; send a smaller refund_amount for a partial refund or 0 for none. Orders without a successful payment get no refund.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Return ID"
+// @Param input body ApproveReturnRequest true "Decision"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/returns/{id}/approve [post]
+func (app *application) approveReturnHandler(w http.ResponseWriter, r *http.Request) {
+	var input ApproveReturnRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	ret, ok := app.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	item, err := app.models.OrderItem.GetById(ret.OrderItemId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	order, err := app.models.OrderDetail.GetById(ret.OrderId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	refunded, err := app.models.Refunds.RefundedAmount(order.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only money which was collected is refunded.
+	payments, err := app.models.Payments.GetAllByOrderID(order.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var paymentId *uuid.UUID
+	for _, p := range payments {
+		if p.Status == data.PaymentStatusSucceeded {
+			paymentId = &p.Id
+			break
+		}
+	}
+
+	maxAmount := 0
+	if paymentId != nil {
+		maxAmount = min(item.Price*ret.Quantity, order.Total-refunded)
+	}
+	amount := maxAmount
+	if input.RefundAmount != nil {
+		amount = *input.RefundAmount
+	}
+	var refund *data.Refund
+	v := validator.New()
+	v.Check(ret.Status == data.ReturnStatusRequested, "status", "return has already been decided")
+	if amount != 0 {
+		v.Check(paymentId != nil, "refund_amount", "must be 0, the order has no successful payment")
+		refund = &data.Refund{
+			OrderId:         order.Id,
+			PaymentId:       paymentId,
+			ReturnRequestId: &ret.Id,
+			Amount:          amount,
+			Status:          data.RefundStatusPending,
+		}
+		data.ValidateRefund(v, refund, maxAmount)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ret.AdminNote = input.Note
+	err = app.models.ReturnRequests.Approve(ret, item.ProductID, refund)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrRefundAmount):
+			v.AddError("refund_amount", "must not be more than the refundable amount")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"return": ret, "refund": refund}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type RejectReturnRequest struct {
+	Note string `json:"note"`
+}
+
+// @Summary Reject a return (admin)
+// @Description Reject a return request with a note for the customer
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Return ID"
+// @Param input body RejectReturnRequest true "Decision"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/returns/{id}/reject [post]
+func (app *application) rejectReturnHandler(w http.ResponseWriter, r *http.Request) {
+	var input RejectReturnRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	ret, ok := app.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	v := validator.New()
+	v.Check(input.Note != "", "note", "must be provided")
+	v.Check(ret.Status == data.ReturnStatusRequested, "status", "return has already been decided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ret.AdminNote = &input.Note
+	err = app.models.ReturnRequests.Reject(ret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"return": ret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type RefundStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// @Summary Settle a refund (admin)
+// @Description Mark a pending refund as "succeeded" or "failed" once it has been paid out
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Refund ID"
+// @Param input body RefundStatusRequest true "Status"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/refunds/{id} [put]
+func (app *application) updateRefundHandler(w http.ResponseWriter, r *http.Request) {
+	var input RefundStatusRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	refund, err := app.models.Refunds.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Status, data.RefundStatusSucceeded, data.RefundStatusFailed), "status", "invalid status value")
+	v.Check(refund.Status == data.RefundStatusPending, "status", "refund has already been settled")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	refund.Status = input.Status
+	err = app.models.Refunds.UpdateStatus(refund)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"refund": refund}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) loadReturn(w http.ResponseWriter, r *http.Request) (*data.ReturnRequest, bool) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	ret, err := app.models.ReturnRequests.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return ret, true
+}
@@ -5,6 +5,7 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 	"net/http"
 	_ "youneon-BE/docs"
+	"youneon-BE/internal/data"
 )
 
 func (app *application) routes() http.Handler {
@@ -14,33 +15,92 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 	router.Handler(http.MethodGet, "/swagger/*any", httpSwagger.WrapHandler)
 
-	router.HandlerFunc(http.MethodPost, "/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
 
-	router.HandlerFunc(http.MethodPost, "/users/login", app.createAuthenticationJWTTokenHandler)
-	router.HandlerFunc(http.MethodGet, "/users/logout", app.requireAuthenticatedUser(app.logoutHandler))
+	router.HandlerFunc(http.MethodPost, "/users", app.rateLimitRoute(app.limiter.register, app.registerUserHandler))
+
+	router.HandlerFunc(http.MethodPost, "/users/login", app.rateLimitRoute(app.limiter.login, app.createAuthenticationJWTTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/users/login/2fa", app.rateLimitRoute(app.limiter.login, app.verifyTwoFactorLoginHandler))
+	router.HandlerFunc(http.MethodGet, "/auth/:provider", app.oidcStartHandler)
+	router.HandlerFunc(http.MethodGet, "/auth/:provider/callback", app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodPost, "/users/logout", app.requireAuthenticatedUser(app.logoutHandler))
 	router.HandlerFunc(http.MethodGet, "/user", app.requireAuthenticatedUser(app.getUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/user", app.requireAuthenticatedUser(app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/user", app.requireAuthenticatedUser(app.deleteAccountHandler))
+	router.HandlerFunc(http.MethodPost, "/user/deletion", app.requireAuthenticatedUser(app.requestAccountDeletionHandler))
+	router.HandlerFunc(http.MethodGet, "/user/export", app.requireAuthenticatedUser(app.exportAccountHandler))
+	router.HandlerFunc(http.MethodGet, "/user/activity", app.requireAuthenticatedUser(app.getUserActivityHandler))
+	router.HandlerFunc(http.MethodGet, "/user/identities", app.requireAuthenticatedUser(app.getUserIdentitiesHandler))
+	router.HandlerFunc(http.MethodGet, "/user/2fa", app.requireAuthenticatedUser(app.getTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/user/2fa/enroll", app.requireAuthenticatedUser(app.enrollTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/user/2fa/enable", app.requireAuthenticatedUser(app.enableTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/user/2fa/disable", app.requireAuthenticatedUser(app.disableTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/user/2fa/recovery-codes", app.requireAuthenticatedUser(app.regenerateRecoveryCodesHandler))
+	router.HandlerFunc(http.MethodPost, "/user/api-keys", app.requireAuthenticatedUser(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/user/api-keys", app.requireAuthenticatedUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodDelete, "/user/api-keys/:id", app.requireAuthenticatedUser(app.revokeAPIKeyHandler))
 
-	router.HandlerFunc(http.MethodGet, "/products/:id", app.getProductHandler)
+	router.HandlerFunc(http.MethodGet, "/products/:id", app.getProductOrSuggestionsHandler)
 	router.HandlerFunc(http.MethodGet, "/products", app.listProductHandler)
 
 	router.HandlerFunc(http.MethodGet, "/tags", app.getAllTags)
 
 	router.HandlerFunc(http.MethodGet, "/categories", app.getAllCategories)
 
-	router.HandlerFunc(http.MethodGet, "/carts", app.requireAuthenticatedUser(app.getCartHandler))
-	router.HandlerFunc(http.MethodPost, "/carts", app.requireAuthenticatedUser(app.insertCartHandler))
-	router.HandlerFunc(http.MethodDelete, "/carts/:id", app.requireAuthenticatedUser(app.removeCartItemHandler))
-	router.HandlerFunc(http.MethodPut, "/carts/:id", app.requireAuthenticatedUser(app.updateCartItemHandler))
+	router.HandlerFunc(http.MethodGet, "/carts", app.requireScope(data.ScopeCartRead, app.getCartHandler))
+	router.HandlerFunc(http.MethodPost, "/carts", app.requireScope(data.ScopeCartWrite, app.insertCartHandler))
+	router.HandlerFunc(http.MethodDelete, "/carts/:id", app.requireScope(data.ScopeCartWrite, app.removeCartItemHandler))
+	router.HandlerFunc(http.MethodPut, "/carts/:id", app.requireScope(data.ScopeCartWrite, app.updateCartItemHandler))
+
+	router.HandlerFunc(http.MethodGet, "/addresses", app.requireScope(data.ScopeAddressesRead, app.getAddressesByUserId))
+	router.HandlerFunc(http.MethodPost, "/addresses", app.requireScope(data.ScopeAddressesWrite, app.createAddressHandler))
+	router.HandlerFunc(http.MethodDelete, "/addresses/:id", app.requireScope(data.ScopeAddressesWrite, app.deleteAddressHandler))
+	router.HandlerFunc(http.MethodPut, "/addresses/:id", app.requireScope(data.ScopeAddressesWrite, app.updateAddressHandler))
+
+	router.HandlerFunc(http.MethodPost, "/orders", app.requireScope(data.ScopeOrdersWrite, app.idempotent(app.createOrderHandler)))
+	router.HandlerFunc(http.MethodPost, "/orders/:id/payments", app.requireScope(data.ScopeOrdersWrite, app.idempotent(app.createPaymentHandler)))
+	router.HandlerFunc(http.MethodGet, "/orders/:id/payments", app.requireScope(data.ScopeOrdersRead, app.listOrderPaymentsHandler))
+
+	router.HandlerFunc(http.MethodPost, "/returns", app.requireAuthenticatedUser(app.createReturnHandler))
+	router.HandlerFunc(http.MethodGet, "/returns", app.requireAuthenticatedUser(app.listReturnsHandler))
+	router.HandlerFunc(http.MethodGet, "/returns/:id", app.requireAuthenticatedUser(app.getReturnHandler))
+
+	router.HandlerFunc(http.MethodGet, "/admin/returns", app.requireAdmin(app.adminListReturnsHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/returns/:id/approve", app.requireAdmin(app.approveReturnHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/returns/:id/reject", app.requireAdmin(app.rejectReturnHandler))
+	router.HandlerFunc(http.MethodPut, "/admin/orders/:id/status", app.requireAdmin(app.updateOrderStatusHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/products/import", app.requireAdmin(app.importProductsHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/products/export", app.requireAdmin(app.exportProductsHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/categories", app.requireAdmin(app.adminListCategoriesHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/categories", app.requireAdmin(app.createCategoryHandler))
+	router.HandlerFunc(http.MethodPatch, "/admin/categories/:id", app.requireAdmin(app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/categories/:id", app.requireAdmin(app.deleteCategoryHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/categories/:id/restore", app.requireAdmin(app.restoreCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/tags", app.requireAdmin(app.adminListTagsHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/tags", app.requireAdmin(app.createTagHandler))
+	router.HandlerFunc(http.MethodPatch, "/admin/tags/:id", app.requireAdmin(app.updateTagHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/tags/:id", app.requireAdmin(app.deleteTagHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/tags/:id/restore", app.requireAdmin(app.restoreTagHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/tags/:id/merge", app.requireAdmin(app.mergeTagHandler))
+	router.HandlerFunc(http.MethodPut, "/admin/tags/:id/products/:product_id", app.requireAdmin(app.attachTagHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/tags/:id/products/:product_id", app.requireAdmin(app.detachTagHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/cache", app.requireAdmin(app.cacheStatsHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/cache", app.requireAdmin(app.clearCacheHandler))
+	router.HandlerFunc(http.MethodPut, "/admin/refunds/:id", app.requireAdmin(app.updateRefundHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/emails", app.requireAdmin(app.listEmailsHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/emails/:id/retry", app.requireAdmin(app.retryEmailHandler))
 
-	router.HandlerFunc(http.MethodGet, "/addresses", app.requireAuthenticatedUser(app.getAddressesByUserId))
-	router.HandlerFunc(http.MethodPost, "/addresses", app.requireAuthenticatedUser(app.createAddressHandler))
-	router.HandlerFunc(http.MethodDelete, "/addresses/:id", app.requireAuthenticatedUser(app.deleteAddressHandler))
-	router.HandlerFunc(http.MethodPut, "/addresses/:id", app.requireAuthenticatedUser(app.updateAddressHandler))
+	router.HandlerFunc(http.MethodGet, "/payments/:provider/return", app.paymentReturnHandler)
+	router.HandlerFunc(http.MethodGet, "/payments/:provider/ipn", app.paymentIPNHandler)
+	router.HandlerFunc(http.MethodPost, "/payments/:provider/ipn", app.paymentIPNHandler)
 
-	router.HandlerFunc(http.MethodPost, "/orders", app.requireAuthenticatedUser(app.createOrderHandler))
+	if app.config.mail.debug {
+		router.HandlerFunc(http.MethodGet, "/debug/mail", app.debugMailHandler)
+		router.HandlerFunc(http.MethodGet, "/debug/mail/preview", app.debugMailPreviewHandler)
+	}
 	//
 	//router.HandlerFunc(http.MethodPost, "/shorten", app.createShortenHandler)
 	//router.HandlerFunc(http.MethodGet, "/:shortID", app.redirectHandler)
-	return app.enableCORS(app.authenticate(router))
+	return app.enableCORS(app.rateLimit(app.authenticate(app.rateLimitUser(router))))
 
 }
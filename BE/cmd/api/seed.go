@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strconv"
+	"youneon-BE/fixtures"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/jsonlog"
+	"youneon-BE/internal/validator"
+)
+
+// fixtureVersion is the version of the fixture file format this binary reads.
+const fixtureVersion = 1
+
+type fixtureFile struct {
+	Version    int               `yaml:"version"`
+	Categories []categoryFixture `yaml:"categories"`
+	Tags       []string          `yaml:"tags"`
+	Products   []productFixture  `yaml:"products"`
+	Users      []userFixture     `yaml:"users"`
+	Orders     []orderFixture    `yaml:"orders"`
+}
+
+// categoryFixture is a category, nested under the category named by Parent,
+// which has to come earlier in the fixtures. The slug is made from the name when
+// it is left out.
+type categoryFixture struct {
+	Name        string `yaml:"name"`
+	Slug        string `yaml:"slug"`
+	Parent      string `yaml:"parent"`
+	SortOrder   int    `yaml:"sort_order"`
+	Image       string `yaml:"image"`
+	Description string `yaml:"description"`
+}
+
+type productFixture struct {
+	Name        string   `yaml:"name"`
+	Price       int      `yaml:"price"`
+	Category    string   `yaml:"category"`
+	Tags        []string `yaml:"tags"`
+	Image       string   `yaml:"image"`
+	Images      []string `yaml:"images"`
+	Description string   `yaml:"description"`
+}
+
+type userFixture struct {
+	Email     string           `yaml:"email"`
+	Password  string           `yaml:"password"`
+	FirstName string           `yaml:"first_name"`
+	LastName  string           `yaml:"last_name"`
+	Telephone string           `yaml:"telephone"`
+	Language  string           `yaml:"language"`
+	Addresses []addressFixture `yaml:"addresses"`
+}
+
+type addressFixture struct {
+	Receiver    string `yaml:"receiver"`
+	Telephone   string `yaml:"telephone"`
+	City        string `yaml:"city"`
+	District    string `yaml:"district"`
+	Ward        string `yaml:"ward"`
+	Detail      string `yaml:"detail"`
+	Description string `yaml:"description"`
+}
+
+type orderFixture struct {
+	User          string             `yaml:"user"`
+	AddressDetail string             `yaml:"address_detail"`
+	Status        string             `yaml:"status"`
+	Items         []orderItemFixture `yaml:"items"`
+}
+
+type orderItemFixture struct {
+	Product  string `yaml:"product"`
+	Quantity int    `yaml:"quantity"`
+}
+
+// seeder loads fixtures through the models, skipping the entries which already exist
+// so it can be run again on a seeded database.
+type seeder struct {
+	models     data.Models
+	categories map[string]uuid.UUID
+	tags       map[string]uuid.UUID
+	// users with orders before this run, their order fixtures are skipped.
+	ordered map[uuid.UUID]bool
+	counts  map[string]int
+}
+
+const seedUsage = `usage: api [flags] seed [-fixtures DIR] [-no-fixtures] [-products N] [-force]`
+
+// runSeed runs the seed subcommand. It loads the embedded fixtures, or the ones in
+// -fixtures, and with -products N adds N generated products for load testing.
+func runSeed(models data.Models, logger *jsonlog.Logger, env string, args []string) error {
+	fset := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	dir := fset.String("fixtures", "", "Directory with the fixture files, the embedded fixtures by default")
+	noFixtures := fset.Bool("no-fixtures", false, "Don't load any fixtures")
+	products := fset.Int("products", 0, "Number of synthetic products to generate")
+	force := fset.Bool("force", false, "Seed even when -env is production")
+	err := fset.Parse(args)
+	if err != nil || fset.NArg() > 0 || *products < 0 {
+		return errors.New(seedUsage)
+	}
+	if env == "production" && !*force {
+		return errors.New("refusing to seed a production database without -force")
+	}
+
+	s := &seeder{models: models, counts: map[string]int{}}
+	err = s.loadCatalogue()
+	if err != nil {
+		return err
+	}
+	if !*noFixtures {
+		var fsys fs.FS = fixtures.FS
+		if *dir != "" {
+			fsys = os.DirFS(*dir)
+		}
+		err = s.loadFixtures(fsys)
+		if err != nil {
+			return err
+		}
+	}
+	if *products > 0 {
+		err = s.generateProducts(*products)
+		if err != nil {
+			return err
+		}
+	}
+	properties := map[string]string{}
+	for kind, n := range s.counts {
+		properties[kind] = strconv.Itoa(n)
+	}
+	logger.PrintInfo("database seeded", properties)
+	return nil
+}
+
+// loadCatalogue looks up the categories and tags which already exist by name.
+func (s *seeder) loadCatalogue() error {
+	s.categories = map[string]uuid.UUID{}
+	categories, err := s.models.Categories.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, c := range categories {
+		s.categories[c.Name] = c.ID
+	}
+	s.tags = map[string]uuid.UUID{}
+	tags, err := s.models.Tags.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		s.tags[t.Name] = t.ID
+	}
+	return nil
+}
+
+// loadFixtures applies the .yaml, .yml and .json files in the root of fsys in name
+// order. JSON files are read as YAML, of which JSON is a subset.
+func (s *seeder) loadFixtures(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return err
+		}
+		var file fixtureFile
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if file.Version != fixtureVersion {
+			return fmt.Errorf("%s: unsupported fixture version %d", entry.Name(), file.Version)
+		}
+		err = s.apply(&file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *seeder) apply(file *fixtureFile) error {
+	for _, c := range file.Categories {
+		err := s.seedCategory(c)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range file.Tags {
+		_, err := s.tag(name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range file.Products {
+		err := s.seedProduct(p)
+		if err != nil {
+			return err
+		}
+	}
+	for _, u := range file.Users {
+		err := s.seedUser(u)
+		if err != nil {
+			return err
+		}
+	}
+	for _, o := range file.Orders {
+		err := s.seedOrder(o)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixtureError reports an entry which doesn't pass validation.
+func fixtureError(kind, name string, errs map[string]string) error {
+	return fmt.Errorf("%s %q: %v", kind, name, errs)
+}
+
+func (s *seeder) seedCategory(c categoryFixture) error {
+	if _, ok := s.categories[c.Name]; ok {
+		return nil
+	}
+	category := &data.Category{Name: c.Name, Slug: c.Slug, SortOrder: c.SortOrder}
+	if category.Slug == "" {
+		category.Slug = data.Slugify(c.Name)
+	}
+	if c.Parent != "" {
+		parentId, ok := s.categories[c.Parent]
+		if !ok {
+			return fixtureError("category", c.Name, map[string]string{"parent": "unknown category"})
+		}
+		category.ParentId = &parentId
+	}
+	if c.Image != "" {
+		category.Image = &c.Image
+	}
+	if c.Description != "" {
+		category.Description = &c.Description
+	}
+	v := validator.New()
+	if data.ValidateCategory(v, category); !v.Valid() {
+		return fixtureError("category", c.Name, v.Errors)
+	}
+	err := s.models.Categories.Insert(category)
+	if err != nil {
+		return err
+	}
+	s.categories[c.Name] = category.ID
+	s.counts["categories"]++
+	return nil
+}
+
+// tag returns the ID of the tag, creating it if needed.
+func (s *seeder) tag(name string) (uuid.UUID, error) {
+	if id, ok := s.tags[name]; ok {
+		return id, nil
+	}
+	if name == "" {
+		return uuid.Nil, fixtureError("tag", name, map[string]string{"name": "must be provided"})
+	}
+	tag := &data.Tag{Name: name}
+	err := s.models.Tags.Insert(tag)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	s.tags[name] = tag.ID
+	s.counts["tags"]++
+	return tag.ID, nil
+}
+
+func (s *seeder) seedProduct(p productFixture) error {
+	_, err := s.models.Products.GetByName(p.Name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+	product := &data.Product{Name: p.Name, Price: p.Price}
+	if p.Image != "" {
+		product.Image = &p.Image
+	}
+	if len(p.Images) > 0 {
+		product.ImageList = &p.Images
+	}
+	if p.Description != "" {
+		product.Description = &p.Description
+	}
+	v := validator.New()
+	data.ValidateProduct(v, product)
+	if p.Category != "" {
+		categoryId, ok := s.categories[p.Category]
+		v.Check(ok, "category", "unknown category")
+		product.CategoryId = categoryId
+	}
+	if !v.Valid() {
+		return fixtureError("product", p.Name, v.Errors)
+	}
+	return s.insertProduct(product, p.Tags)
+}
+
+func (s *seeder) insertProduct(product *data.Product, tags []string) error {
+	err := s.models.Products.Insert(product)
+	if err != nil {
+		return err
+	}
+	for _, name := range tags {
+		tagId, err := s.tag(name)
+		if err != nil {
+			return err
+		}
+		err = s.models.Tags.AddToProduct(tagId, product.Id)
+		if err != nil {
+			return err
+		}
+	}
+	s.counts["products"]++
+	return nil
+}
+
+func (s *seeder) seedUser(u userFixture) error {
+	_, err := s.models.Users.GetByEmail(u.Email)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+	user := &data.User{
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Telephone: u.Telephone,
+		Language:  u.Language,
+	}
+	if user.Language == "" {
+		user.Language = data.Languages[0]
+	}
+	err = user.Password.Set(u.Password)
+	if err != nil {
+		return err
+	}
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return fixtureError("user", u.Email, v.Errors)
+	}
+	err = s.models.Users.Insert(user)
+	if err != nil {
+		return err
+	}
+	s.counts["users"]++
+	for _, a := range u.Addresses {
+		address := &data.Address{
+			UserId:      user.ID,
+			City:        a.City,
+			District:    a.District,
+			Ward:        a.Ward,
+			Detail:      a.Detail,
+			Telephone:   a.Telephone,
+			Receiver:    a.Receiver,
+			Description: a.Description,
+		}
+		if data.ValidateAddress(v, address); !v.Valid() {
+			return fixtureError("address of user", u.Email, v.Errors)
+		}
+		err = s.models.Address.Insert(address)
+		if err != nil {
+			return err
+		}
+		s.counts["addresses"]++
+	}
+	return nil
+}
+
+// seedOrder creates the order unless its customer already had orders before this
+// run. The items are priced at the current product prices. Like a checkout, creating
+// the order removes the ordered products from the customer's cart.
+func (s *seeder) seedOrder(o orderFixture) error {
+	user, err := s.models.Users.GetByEmail(o.User)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fixtureError("order of", o.User, map[string]string{"user": "unknown user"})
+		}
+		return err
+	}
+	if s.ordered == nil {
+		s.ordered = map[uuid.UUID]bool{}
+	}
+	ordered, ok := s.ordered[user.ID]
+	if !ok {
+		orders, err := s.models.OrderDetail.GetAllByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		ordered = len(orders) > 0
+		s.ordered[user.ID] = ordered
+	}
+	if ordered {
+		return nil
+	}
+
+	order := &data.OrderDetail{UserId: user.ID, AddressDetail: o.AddressDetail, Status: o.Status}
+	if order.Status == "" {
+		order.Status = data.OrderStatusPending
+	}
+	v := validator.New()
+	v.Check(len(o.Items) > 0, "items", "must contain at least one item")
+	v.Check(validator.PermittedValue(order.Status, data.OrderStatusPending, data.OrderStatusConfirmed, data.OrderStatusPaid,
+		data.OrderStatusShipped, data.OrderStatusDelivered, data.OrderStatusCancelled), "status", "invalid status")
+	items := make([]*data.OrderItem, 0, len(o.Items))
+	for _, item := range o.Items {
+		product, err := s.models.Products.GetByName(item.Product)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				v.AddError("items", "unknown product "+strconv.Quote(item.Product))
+				continue
+			}
+			return err
+		}
+		v.Check(item.Quantity > 0, "items", "quantity must be greater than zero")
+		items = append(items, &data.OrderItem{ProductID: product.Id, Quantity: item.Quantity, Price: product.Price})
+		order.Total += product.Price * item.Quantity
+	}
+	if !v.Valid() {
+		return fixtureError("order of", o.User, v.Errors)
+	}
+	err = s.models.OrderDetail.Create(order, items)
+	if err != nil {
+		return err
+	}
+	s.counts["orders"]++
+	return nil
+}
+
+var (
+	syntheticShapes = []string{"Heart", "Star", "Moon", "Cactus", "Rainbow", "Lightning", "Flamingo", "Coffee", "Wave", "Rocket"}
+	syntheticColors = []string{"Pink", "Blue", "Warm White", "Red", "Green", "Purple", "Amber", "Ice Blue"}
+)
+
+// generateProducts inserts n products with random names, prices, categories and
+// tags drawn from the existing catalogue, to load test ProductModel.GetAll. They are
+// not checked for duplicates.
+func (s *seeder) generateProducts(n int) error {
+	var categories, tags []string
+	for name := range s.categories {
+		categories = append(categories, name)
+	}
+	for name := range s.tags {
+		tags = append(tags, name)
+	}
+	for i := 1; i <= n; i++ {
+		shape := syntheticShapes[rand.IntN(len(syntheticShapes))]
+		color := syntheticColors[rand.IntN(len(syntheticColors))]
+		description := fmt.Sprintf("%s neon %s, generated for load testing.", color, shape)
+		product := &data.Product{
+			Name:        fmt.Sprintf("Neon %s %s #%d", color, shape, rand.IntN(1_000_000)),
+			Price:       (rand.IntN(300) + 20) * 10_000,
+			Description: &description,
+		}
+		if len(categories) > 0 {
+			product.CategoryId = s.categories[categories[rand.IntN(len(categories))]]
+		}
+		var productTags []string
+		for _, j := range rand.Perm(len(tags))[:min(len(tags), rand.IntN(4))] {
+			productTags = append(productTags, tags[j])
+		}
+		err := s.insertProduct(product, productTags)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
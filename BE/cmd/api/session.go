@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/oidc"
+)
+
+// Cookie authentication keeps the token out of reach of JavaScript: it travels in an
+// HttpOnly session cookie. Because the browser attaches that cookie to requests
+// other sites trigger too, state-changing requests must also carry a CSRF token in
+// the X-CSRF-Token header (double submit). The token is handed out at login, also
+// set in a cookie the FE can read, and its hash is a claim of the session JWT, so a
+// CSRF cookie planted by a sibling subdomain doesn't match.
+const csrfHeader = "X-CSRF-Token"
+
+func (app *application) sessionCookie(name, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   app.config.cookie.domain,
+		MaxAge:   int(app.config.jwt.ttl.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   app.config.cookie.secure,
+		SameSite: app.config.cookie.sameSite,
+	}
+}
+
+// startSession signs a session token bound to a fresh CSRF token and sets both
+// cookies. It returns the CSRF token.
+func (app *application) startSession(w http.ResponseWriter, user *data.User, methods ...string) (string, error) {
+	csrf := oidc.RandomString()
+	claims := app.authenticationClaims(user, methods...)
+	claims.Set["csrf"] = csrfHash(csrf)
+	token, err := app.keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, app.sessionCookie(app.config.cookie.name, string(token), true))
+	http.SetCookie(w, app.sessionCookie(app.config.cookie.name+"_csrf", csrf, false))
+	return csrf, nil
+}
+
+func (app *application) endSession(w http.ResponseWriter) {
+	for _, name := range []string{app.config.cookie.name, app.config.cookie.name + "_csrf"} {
+		cookie := app.sessionCookie(name, "", name == app.config.cookie.name)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// validCSRF checks the X-CSRF-Token header of a cookie authenticated request
+// against the CSRF cookie and the hash in the session token.
+func (app *application) validCSRF(r *http.Request, sessionHash string) bool {
+	header := r.Header.Get(csrfHeader)
+	cookie, err := r.Cookie(app.config.cookie.name + "_csrf")
+	if header == "" || err != nil || sessionHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(csrfHash(header)), []byte(sessionHash)) == 1
+}
+
+func csrfHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isSafeMethod reports whether the method doesn't change state, and so doesn't
+// need CSRF protection.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/validator"
+)
+
+type TagRequest struct {
+	Name string `json:"name"`
+}
+
+type MergeTagRequest struct {
+	Into string `json:"into"`
+}
+
+// readTag reads the tag named by the id parameter, sending a 404 response and
+// returning nil if there's no such tag.
+func (app *application) readTag(w http.ResponseWriter, r *http.Request) *data.Tag {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	tag, err := app.models.Tags.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return tag
+}
+
+// @Summary List all tags
+// @Description List every tag, deleted ones included
+// @Tags admin
+// @Produce json
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags [get]
+func (app *application) adminListTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.models.Tags.GetAllWithDeleted()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Create a tag
+// @Description Create a tag. Names are unique, ignoring case.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param tag body TagRequest true "tag"
+// @Success 201 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags [post]
+func (app *application) createTagHandler(w http.ResponseWriter, r *http.Request) {
+	var input TagRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tag := &data.Tag{Name: strings.TrimSpace(input.Name)}
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Tags.Insert(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateName):
+			v.AddError("name", "a tag with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Rename a tag
+// @Description Rename a tag
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "tag id"
+// @Param tag body TagRequest true "tag"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags/{id} [patch]
+func (app *application) updateTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := app.readTag(w, r)
+	if tag == nil {
+		return
+	}
+	var input TagRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tag.Name = strings.TrimSpace(input.Name)
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Tags.Update(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateName):
+			v.AddError("name", "a tag with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Delete a tag
+// @Description Soft-delete a tag. Products keep it, so restoring it tags them again.
+// @Tags admin
+// @Produce json
+// @Param id path string true "tag id"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags/{id} [delete]
+func (app *application) deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUUIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Tags.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Restore a tag
+// @Description Restore a deleted tag, unless another tag has taken its name
+// @Tags admin
+// @Produce json
+// @Param id path string true "tag id"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags/{id}/restore [post]
+func (app *application) restoreTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := app.readTag(w, r)
+	if tag == nil {
+		return
+	}
+	err := app.models.Tags.Restore(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateName):
+			v := validator.New()
+			v.AddError("name", "a tag with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Merge two tags
+// @Description Move the products of the tag to the tag named by "into" and delete it
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "tag id"
+// @Param merge body MergeTagRequest true "target tag"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags/{id}/merge [post]
+func (app *application) mergeTagHandler(w http.ResponseWriter, r *http.Request) {
+	source := app.readTag(w, r)
+	if source == nil {
+		return
+	}
+	var input MergeTagRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	targetId, err := uuid.Parse(input.Into)
+	v.Check(err == nil, "into", "must be a valid id")
+	v.Check(targetId != source.ID, "into", "must be another tag")
+	v.Check(!source.IsDelete, "tag", "has been deleted")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	target, err := app.models.Tags.Get(targetId)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if v.Check(err == nil && !target.IsDelete, "into", "unknown tag"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tags.Merge(source.ID, target.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tag": target}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readProductTagParams reads the tag and product ids of the product tag routes,
+// sending a 404 response if either is malformed or unknown.
+func (app *application) readProductTagParams(w http.ResponseWriter, r *http.Request) (*data.Tag, *data.Product) {
+	tag := app.readTag(w, r)
+	if tag == nil {
+		return nil, nil
+	}
+	productId, err := uuid.Parse(app.readStringParam(r, "product_id"))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, nil
+	}
+	product, err := app.models.Products.Get(productId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil
+	}
+	return tag, product
+}
+
+// @Summary Tag a product
+// @Description Attach the tag to the product. Attaching it twice is a no-op.
+// @Tags admin
+// @Produce json
+// @Param id path string true "tag id"
+// @Param product_id path string true "product id"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags/{id}/products/{product_id} [put]
+func (app *application) attachTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, product := app.readProductTagParams(w, r)
+	if tag == nil {
+		return
+	}
+	if tag.IsDelete {
+		v := validator.New()
+		v.AddError("tag", "has been deleted")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err := app.models.Tags.AddToProduct(tag.ID, product.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag attached"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Untag a product
+// @Description Detach the tag from the product
+// @Tags admin
+// @Produce json
+// @Param id path string true "tag id"
+// @Param product_id path string true "product id"
+// @Success 200 {object} envelope
+// @Security ApiKeyAuth
+// @Router /admin/tags/{id}/products/{product_id} [delete]
+func (app *application) detachTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, product := app.readProductTagParams(w, r)
+	if tag == nil {
+		return
+	}
+	err := app.models.Tags.RemoveFromProduct(tag.ID, product.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag detached"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,16 +4,20 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"github.com/google/uuid"
 	"github.com/pascaldekloe/jwt"
 	"net/http"
 	"time"
 	"youneon-BE/internal/data"
+	"youneon-BE/internal/keyring"
 	"youneon-BE/internal/validator"
 )
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Cookie asks for an HttpOnly session cookie instead of a bearer token.
+	Cookie bool `json:"cookie"`
 }
 
 // @Summary Create a new authentication token for a user
@@ -38,48 +42,192 @@ func (app *application) createAuthenticationJWTTokenHandler(w http.ResponseWrite
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	event := &data.LoginEvent{
+		Email:     input.Email,
+		IP:        app.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
 	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if user != nil {
+		event.UserId = &user.ID
+	}
+
+	// Refuse to even look at the password while the account or the IP address has
+	// to wait, otherwise the attacker still learns whether the guess was right.
+	wait, reason, err := app.loginWait(input.Email, event.IP)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
-		default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		event.Reason = reason
+		err = app.models.LoginEvents.Insert(event)
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
+			return
 		}
+		app.loginThrottledResponse(w, r, wait, reason)
 		return
 	}
+
+	if user == nil {
+		event.Reason = data.LoginReasonUnknownEmail
+		err = app.models.LoginEvents.Insert(event)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	if !match {
+		event.Reason = data.LoginReasonInvalidPassword
+		locked, err := app.recordLoginFailure(event, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if locked {
+			app.loginThrottledResponse(w, r, app.config.login.lockoutDuration, data.LoginReasonLocked)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
+
+	tokens, err := app.completeLogin(w, user, event, "pwd", input.Cookie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, tokens, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// completeLogin is called once the user proved their identity with the given
+// method (a password, a social login). With 2FA enabled that isn't enough: it hands
+// out a short-lived challenge token which POST /users/login/2fa exchanges, together
+// with a code, for the authentication token. Either way the attempt is recorded.
+func (app *application) completeLogin(w http.ResponseWriter, user *data.User, event *data.LoginEvent, method string, cookie bool) (envelope, error) {
+	tf, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+	if tf.Enabled() {
+		event.Reason = data.LoginReasonSecondFactor
+		err = app.models.LoginEvents.Insert(event)
+		if err != nil {
+			return nil, err
+		}
+		challenge, err := app.newChallengeToken(user, method)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"two_factor_required": true, "challenge_token": string(challenge)}, nil
+	}
+
+	event.Success = true
+	err = app.models.LoginEvents.Insert(event)
+	if err != nil {
+		return nil, err
+	}
+	return app.issueAuthentication(w, user, cookie, method)
+}
+
+// issueAuthentication returns the bearer token, or with cookie sets the session
+// cookies and returns the CSRF token the client has to send back.
+func (app *application) issueAuthentication(w http.ResponseWriter, user *data.User, cookie bool, methods ...string) (envelope, error) {
+	if cookie && app.config.cookie.enabled {
+		csrf, err := app.startSession(w, user, methods...)
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"csrf_token": csrf}, nil
+	}
+	jwtBytes, err := app.newAuthenticationToken(user, methods...)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"authentication_token": string(jwtBytes)}, nil
+}
+
+// challengeAudience is the audience of challenge tokens. It differs from the one of
+// authentication tokens so authenticate() never accepts them.
+func (app *application) challengeAudience() string {
+	return app.config.jwt.issuer + "/2fa"
+}
+
+// newAuthenticationToken signs the JWT the client authenticates with. The amr
+// claim lists how the user proved who they are; requireAdmin() insists on "otp".
+func (app *application) newAuthenticationToken(user *data.User, methods ...string) ([]byte, error) {
+	claims := app.authenticationClaims(user, methods...)
+	// Sign the JWT claims with the current key of the keyring, which also sets the
+	// kid header. This returns a []byte slice containing the JWT as a base64
+	// encoded string.
+	return app.keys.Sign(claims)
+}
+
+func (app *application) authenticationClaims(user *data.User, methods ...string) *jwt.Claims {
 	// Create a JWT claims struct containing the user ID as the subject, with an issued
-	// time of now and validity window of the next 24 hours. We also set the issuer and
-	// audience to a unique identifier for our application.
+	// time of now and validity window of the configured lifetime. We also set the
+	// issuer and audience to a unique identifier for our application.
 	var claims jwt.Claims
 	claims.Subject = user.ID.String()
 	claims.Issued = jwt.NewNumericTime(time.Now())
 	claims.NotBefore = jwt.NewNumericTime(time.Now())
-	claims.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour))
-	claims.Issuer = "chatappbe.minhtc47.net"
-	claims.Audiences = []string{"chatappfe.minhtc47.net"}
-	// Sign the JWT claims using the HMAC-SHA256 algorithm and the secret key from the
-	// application config. This returns a []byte slice containing the JWT as a base64
-	// encoded string.
-	jwtBytes, err := claims.HMACSign(jwt.HS256, []byte(app.config.jwt.secret))
+	claims.Expires = jwt.NewNumericTime(time.Now().Add(app.config.jwt.ttl))
+	claims.Issuer = app.config.jwt.issuer
+	claims.Audiences = []string{app.config.jwt.audience}
+	claims.Set = map[string]interface{}{"amr": methods}
+	return &claims
+}
+
+// newChallengeToken signs the token of the first sign-in step. Its amr claim carries
+// the method used in that step over to the authentication token.
+func (app *application) newChallengeToken(user *data.User, method string) ([]byte, error) {
+	var claims jwt.Claims
+	claims.Subject = user.ID.String()
+	claims.Issued = jwt.NewNumericTime(time.Now())
+	claims.NotBefore = jwt.NewNumericTime(time.Now())
+	claims.Expires = jwt.NewNumericTime(time.Now().Add(5 * time.Minute))
+	claims.Issuer = app.config.jwt.issuer
+	claims.Audiences = []string{app.challengeAudience()}
+	claims.Set = map[string]interface{}{"amr": []string{method}}
+	return app.keys.Sign(&claims)
+}
+
+// parseChallengeToken returns the user a valid challenge token was issued to and
+// the method of the first step.
+func (app *application) parseChallengeToken(token string) (*data.User, string, error) {
+	claims, err := app.keys.Check([]byte(token))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		return nil, "", err
 	}
-	// Convert the []byte slice to a string and return it in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_token": string(jwtBytes)}, nil)
+	if !claims.Valid(time.Now()) || claims.Issuer != app.config.jwt.issuer || !claims.AcceptAudience(app.challengeAudience()) {
+		return nil, "", errors.New("invalid challenge token")
+	}
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		return nil, "", err
 	}
+	method := "pwd"
+	if amr, ok := claims.Set["amr"].([]interface{}); ok && len(amr) == 1 {
+		method, _ = amr[0].(string)
+	}
+	user, err := app.models.Users.Get(id)
+	return user, method, err
 }
 
 func GenerateToken() string {
@@ -100,13 +248,50 @@ func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	token := app.contextGetToken(r)
 	userId := app.contextGetUser(r).ID.String()
-	err := app.redis.storeLogoutToken(token, userId, 24*time.Hour)
+	err := app.redis.storeLogoutToken(token, userId, app.config.jwt.ttl)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.endSession(w)
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "logout successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// @Summary JSON Web Key Set
+// @Description Public keys our tokens are signed with, for other services to verify them. Keys are published ahead of their activation and kept until the tokens they signed have expired.
+// @Tags users
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.keys.JWKS()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reloadSigningKeys picks up keys added to the keys directory, so the next rotation
+// can be staged without a restart. It is meant to be started with background() and
+// runs until app.quit is closed.
+func (app *application) reloadSigningKeys() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.quit:
+			return
+		case <-ticker.C:
+		}
+		keys, err := keyring.Load(app.config.jwt.keysDir)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		app.keys.Replace(keys)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"time"
+	"youneon-BE/internal/data"
+	"youneon-BE/internal/totp"
+	"youneon-BE/internal/validator"
+)
+
+const totpIssuer = "YOUNEON"
+
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+	Cookie         bool   `json:"cookie"`
+}
+
+func validateTwoFactorCode(v *validator.Validator, code, recoveryCode string) {
+	v.Check(code != "" || recoveryCode != "", "code", "must be provided")
+	v.Check(code == "" || len(code) == totp.Digits, "code", "must be 6 digits long")
+}
+
+// checkSecondFactor verifies a TOTP code, or a recovery code when no TOTP code was
+// given, and burns it so it can't be used again.
+func (app *application) checkSecondFactor(tf *data.TwoFactor, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		counter, ok := totp.ValidateAfter(tf.Secret, code, time.Now(), tf.LastCounter)
+		if !ok {
+			return false, nil
+		}
+		err := app.models.TwoFactor.UseCounter(tf.UserId, counter)
+		if errors.Is(err, data.ErrEditConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	err := app.models.TwoFactor.UseRecoveryCode(tf.UserId, data.HashRecoveryCode(recoveryCode))
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// @Summary Two-factor authentication status
+// @Description Tell whether 2FA is enabled on the current user's account and how many recovery codes are left.
+// @Tags 2fa
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /user/2fa [get]
+func (app *application) getTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	tf, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	status := envelope{"enabled": tf.Enabled(), "required": user.IsAdmin()}
+	if tf.Enabled() {
+		status["enabled_at"] = tf.EnabledAt
+		left, err := app.models.TwoFactor.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		status["recovery_codes_left"] = left
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"two_factor": status}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Start 2FA enrollment
+// @Description Generate a new TOTP secret. The response carries the otpauth:// provisioning URI and the same URI as a QR code PNG (data URL) to scan with an authenticator app. 2FA is only turned on once a code is confirmed with /user/2fa/enable.
+// @Tags 2fa
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /user/2fa/enroll [post]
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	tf := &data.TwoFactor{UserId: user.ID, Secret: secret}
+	err = app.models.TwoFactor.Enroll(tf)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	uri := totp.ProvisioningURI(totpIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"secret":           secret,
+		"provisioning_uri": uri,
+		"qr_code":          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Enable 2FA
+// @Description Confirm enrollment with a code from the authenticator app. The response lists the recovery codes; they are only shown this once.
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param code body TwoFactorCodeRequest true "code from the authenticator app"
+// @Success 200 {object} map[string]interface{}
+// @Router /user/2fa/enable [post]
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input TwoFactorCodeRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	validateTwoFactorCode(v, input.Code, "")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	tf, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "start the enrollment with /user/2fa/enroll first")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if tf.Enabled() {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+	counter, ok := totp.Validate(tf.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is not valid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, hashes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TwoFactor.Enable(tf, counter, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"enabled_at": tf.EnabledAt, "recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Disable 2FA
+// @Description Turn 2FA off, confirmed with a current code or a recovery code. Admins can't turn it off.
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param code body TwoFactorCodeRequest true "code or recovery code"
+// @Success 200 {object} map[string]interface{}
+// @Router /user/2fa/disable [post]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.IsAdmin() {
+		app.errorResponse(w, r, http.StatusForbidden, "two-factor authentication is mandatory for admin accounts")
+		return
+	}
+	tf, ok := app.confirmSecondFactor(w, r)
+	if !ok {
+		return
+	}
+	err := app.models.TwoFactor.Delete(tf.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes with a new set, confirmed with a current code or a recovery code.
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param code body TwoFactorCodeRequest true "code or recovery code"
+// @Success 200 {object} map[string]interface{}
+// @Router /user/2fa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	tf, ok := app.confirmSecondFactor(w, r)
+	if !ok {
+		return
+	}
+	codes, hashes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TwoFactor.ReplaceRecoveryCodes(tf.UserId, hashes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmSecondFactor reads a TwoFactorCodeRequest and checks it against the current
+// user's enabled second factor. When it returns false the response has been sent.
+func (app *application) confirmSecondFactor(w http.ResponseWriter, r *http.Request) (*data.TwoFactor, bool) {
+	var input TwoFactorCodeRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+	v := validator.New()
+	validateTwoFactorCode(v, input.Code, input.RecoveryCode)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+	tf, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !tf.Enabled() {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is not enabled")
+		return nil, false
+	}
+	ok, err := app.checkSecondFactor(tf, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		v.AddError("code", "is not valid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+	return tf, true
+}
+
+// @Summary Complete a two-factor sign-in
+// @Description Exchange the challenge token returned by /users/login, together with a code from the authenticator app or a recovery code, for the authentication token.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param login body TwoFactorLoginRequest true "challenge and code"
+// @Success 200 {string} authentication_token
+// @Router /users/login/2fa [post]
+func (app *application) verifyTwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input TwoFactorLoginRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.ChallengeToken != "", "challenge_token", "must be provided")
+	validateTwoFactorCode(v, input.Code, input.RecoveryCode)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, method, err := app.parseChallengeToken(input.ChallengeToken)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	event := &data.LoginEvent{
+		UserId:    &user.ID,
+		Email:     user.Email,
+		IP:        app.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	// Wrong codes count towards the same lockout as wrong passwords.
+	wait, reason, err := app.loginWait(user.Email, event.IP)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		event.Reason = reason
+		err = app.models.LoginEvents.Insert(event)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.loginThrottledResponse(w, r, wait, reason)
+		return
+	}
+
+	tf, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !tf.Enabled() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	ok, err := app.checkSecondFactor(tf, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		event.Reason = data.LoginReasonInvalidCode
+		locked, err := app.recordLoginFailure(event, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if locked {
+			app.loginThrottledResponse(w, r, app.config.login.lockoutDuration, data.LoginReasonLocked)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	event.Success = true
+	err = app.models.LoginEvents.Insert(event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	tokens, err := app.issueAuthentication(w, user, input.Cookie, method, "otp")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, tokens, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
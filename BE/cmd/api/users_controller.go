@@ -13,6 +13,7 @@ type UserRequest struct {
 	LastName  string `json:"last_name"`
 	Password  string `json:"password"`
 	Telephone string `json:"telephone"`
+	Language  string `json:"language"`
 }
 
 // @Summary Register a user
@@ -35,6 +36,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		LastName:  input.LastName,
 		Email:     input.Email,
 		Telephone: input.Telephone,
+		Language:  input.Language,
+	}
+	if user.Language == "" {
+		user.Language = "vi"
 	}
 	err = user.Password.Set(input.Password)
 	if err != nil {
@@ -84,6 +89,63 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type UpdateUserRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Telephone *string `json:"telephone"`
+	Language  *string `json:"language"`
+}
+
+// @Summary Update the current user
+// @Description Update the profile of the current user. Only the fields present in the body are changed; language ("vi" or "en") selects the language of emails.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param user body UpdateUserRequest true "profile"
+// @Success 200 {object} data.User
+// @Router /user [patch]
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input UpdateUserRequest
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if input.FirstName != nil {
+		user.FirstName = *input.FirstName
+	}
+	if input.LastName != nil {
+		user.LastName = *input.LastName
+	}
+	if input.Telephone != nil {
+		user.Telephone = *input.Telephone
+	}
+	if input.Language != nil {
+		user.Language = *input.Language
+	}
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // create login handler
 //func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request) {
 //	var input struct {
@@ -136,3 +198,43 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 //		app.serverErrorResponse(w, r, err)
 //	}
 //}
+
+// @Summary Recent sign-in activity
+// @Description List the latest sign-in attempts on the current user's account, successful or not, newest first.
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} data.LoginEvent
+// @Router /user/activity [get]
+func (app *application) getUserActivityHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	events, err := app.models.LoginEvents.GetAllByUserID(user.ID, 20)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"login_events": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// @Summary Linked social logins
+// @Description List the external identities (Google, Facebook...) linked to the current user's account.
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} data.UserIdentity
+// @Router /user/identities [get]
+func (app *application) getUserIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	identities, err := app.models.UserIdentities.GetAllByUserID(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"identities": identities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
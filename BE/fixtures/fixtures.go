@@ -0,0 +1,10 @@
+// Package fixtures embeds the data loaded by the seed subcommand of the API: the
+// demo catalogue, a demo customer and a few of their orders. Files are applied in
+// name order; entries which already exist are left alone, so new fixtures can be
+// added to a database which was seeded before.
+package fixtures
+
+import "embed"
+
+//go:embed *.yaml
+var FS embed.FS
@@ -0,0 +1,150 @@
+// Package cache keeps the results of read queries in memory or in Redis. Values
+// are stored as JSON under keys made from a namespace and the query parameters,
+// expire after a TTL, and are dropped all at once by Invalidate when the data
+// behind them changes.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the cached values. Clear removes every key with the prefix.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Clear(ctx context.Context, prefix string) error
+}
+
+// Stats counts the lookups of a namespace. Errors are store failures, which are
+// served from the database like misses.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+type counters struct {
+	hits, misses, errors atomic.Int64
+}
+
+type Cache struct {
+	store  Store
+	prefix string
+	ttl    time.Duration
+
+	mu                  sync.Mutex
+	namespaces          map[string]*counters
+	invalidations       atomic.Int64
+	failedInvalidations atomic.Int64
+}
+
+// New returns a cache storing its keys under prefix. A nil *Cache is valid and
+// caches nothing.
+func New(store Store, prefix string, ttl time.Duration) *Cache {
+	return &Cache{store: store, prefix: prefix, ttl: ttl, namespaces: map[string]*counters{}}
+}
+
+func (c *Cache) counters(namespace string) *counters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.namespaces[namespace]
+	if !ok {
+		n = &counters{}
+		c.namespaces[namespace] = n
+	}
+	return n
+}
+
+// Key makes the key of a query from its parameters, which should be normalized
+// so equivalent queries share a key.
+func Key(params ...any) string {
+	js, err := json.Marshal(params)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Fetch returns the cached value of the key in the namespace, or loads, caches
+// and returns it. Errors of load aren't cached, and a failing store only counts
+// as an error.
+func Fetch[T any](c *Cache, namespace string, key string, load func() (T, error)) (T, error) {
+	if c == nil {
+		return load()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n := c.counters(namespace)
+	key = c.prefix + namespace + ":" + key
+
+	js, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		n.errors.Add(1)
+	}
+	if ok {
+		var value T
+		if json.Unmarshal(js, &value) == nil {
+			n.hits.Add(1)
+			return value, nil
+		}
+		n.errors.Add(1)
+	}
+	n.misses.Add(1)
+
+	// A value loaded while the cache was invalidated may be stale already.
+	generation := c.invalidations.Load()
+	value, err := load()
+	if err != nil || c.invalidations.Load() != generation {
+		return value, err
+	}
+	js, err = json.Marshal(value)
+	if err == nil {
+		err = c.store.Set(ctx, key, js, c.ttl)
+	}
+	if err != nil {
+		n.errors.Add(1)
+	}
+	return value, nil
+}
+
+// Invalidate drops every cached value. A failure is only counted, the values
+// then stay until they expire.
+func (c *Cache) Invalidate() {
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.invalidations.Add(1)
+	if c.store.Clear(ctx, c.prefix) != nil {
+		c.failedInvalidations.Add(1)
+	}
+}
+
+// Report is a snapshot of the counters of a cache.
+type Report struct {
+	Namespaces          map[string]Stats `json:"namespaces"`
+	Invalidations       int64            `json:"invalidations"`
+	FailedInvalidations int64            `json:"failed_invalidations"`
+}
+
+func (c *Cache) Stats() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := Report{
+		Namespaces:          make(map[string]Stats, len(c.namespaces)),
+		Invalidations:       c.invalidations.Load(),
+		FailedInvalidations: c.failedInvalidations.Load(),
+	}
+	for name, n := range c.namespaces {
+		report.Namespaces[name] = Stats{Hits: n.hits.Load(), Misses: n.misses.Load(), Errors: n.errors.Load()}
+	}
+	return report
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+	"youneon-BE/internal/validator"
+)
+
+// Scopes an API key can be granted. A route reachable with an API key names the
+// scope it needs; everything else, including key management and the admin area,
+// needs an interactive login.
+const (
+	ScopeCartRead       = "cart:read"
+	ScopeCartWrite      = "cart:write"
+	ScopeAddressesRead  = "addresses:read"
+	ScopeAddressesWrite = "addresses:write"
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+)
+
+var APIKeyScopes = []string{ScopeCartRead, ScopeCartWrite, ScopeAddressesRead, ScopeAddressesWrite, ScopeOrdersRead, ScopeOrdersWrite}
+
+const apiKeyPrefix = "yk_"
+
+// APIKey lets a partner integration act as the user who created it. Only the
+// SHA-256 hash of the key is stored; Plaintext is set once, when the key is
+// generated, and Prefix is kept so the user can tell their keys apart.
+type APIKey struct {
+	Id         uuid.UUID  `json:"id"`
+	UserId     uuid.UUID  `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Plaintext  string     `json:"key,omitempty"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return validator.PermittedValue(scope, k.Scopes...)
+}
+
+// Active reports whether the key may still be used.
+func (k *APIKey) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// GenerateAPIKey creates a new random key for the user.
+func GenerateAPIKey(userId uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := apiKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	return &APIKey{
+		UserId:    userId,
+		Name:      name,
+		Prefix:    plaintext[:len(apiKeyPrefix)+6],
+		Plaintext: plaintext,
+		Hash:      HashAPIKey(plaintext),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func HashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range key.Scopes {
+		v.Check(validator.PermittedValue(scope, APIKeyScopes...), "scopes", "must only contain "+strings.Join(APIKeyScopes, ", "))
+	}
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	args := []interface{}{key.UserId, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.ExpiresAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.Id, &key.CreatedAt)
+}
+
+// GetByHash returns the key with the given hash, revoked or expired ones included;
+// use Active to tell.
+func (m APIKeyModel) GetByHash(hash []byte) (*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE key_hash = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (m APIKeyModel) GetAllByUserID(id uuid.UUID) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke disables the user's key for good. It returns ErrRecordNotFound when the
+// user has no such key or it was revoked already.
+func (m APIKeyModel) Revoke(id uuid.UUID, userId uuid.UUID) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records that the key was used. To spare a write per request the
+// time is only updated once a minute.
+func (m APIKeyModel) TouchLastUsed(id uuid.UUID) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.Id,
+		&key.UserId,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -3,79 +3,280 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
+	"youneon-BE/internal/cache"
+	"youneon-BE/internal/validator"
 )
 
+var SlugRX = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
 type Category struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description"`
-	CreateAt    time.Time `json:"created_at"`
-	ModifiedAt  time.Time `json:"modified_at"`
-	IsDeleted   bool      `json:"is_deleted"`
+	ID          uuid.UUID   `json:"id"`
+	ParentId    *uuid.UUID  `json:"parent_id"`
+	Name        string      `json:"name"`
+	Slug        string      `json:"slug"`
+	Description *string     `json:"description"`
+	Image       *string     `json:"image"`
+	SortOrder   int         `json:"sort_order"`
+	CreateAt    time.Time   `json:"created_at"`
+	ModifiedAt  time.Time   `json:"modified_at"`
+	IsDeleted   bool        `json:"is_deleted"`
+	Children    []*Category `json:"children,omitempty"`
+}
+
+// Slugify turns a name into a slug: lower case ASCII letters and digits joined by
+// dashes. Vietnamese diacritics are dropped, so "Đèn Neon" becomes "den-neon".
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			r = 'd'
+		case r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)):
+			dash = b.Len() > 0
+			continue
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(len(category.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(category.Slug == "" || validator.Matches(category.Slug, SlugRX), "slug", "must only contain lower case letters, digits and dashes")
+	v.Check(category.SortOrder >= 0, "sort_order", "must not be negative")
+	v.Check(category.ParentId == nil || *category.ParentId != category.ID, "parent_id", "must not be the category itself")
+}
+
+// CategoryTree nests the categories under their parents, each level sorted by
+// sort order and name. Categories whose parent isn't in the list are roots.
+func CategoryTree(categories []*Category) []*Category {
+	byId := make(map[uuid.UUID]*Category, len(categories))
+	for _, c := range categories {
+		c.Children = nil
+		byId[c.ID] = c
+	}
+	roots := []*Category{}
+	for _, c := range categories {
+		if c.ParentId != nil {
+			if parent, ok := byId[*c.ParentId]; ok {
+				parent.Children = append(parent.Children, c)
+				continue
+			}
+		}
+		roots = append(roots, c)
+	}
+	sortCategories(roots)
+	return roots
+}
+
+func sortCategories(categories []*Category) {
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	for _, c := range categories {
+		sortCategories(c.Children)
+	}
 }
 
+// CategoryModel caches GetAll in Cache, when set, until the catalogue changes.
 type CategoryModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *cache.Cache
+}
+
+const categoryColumns = `id, parent_id, name, slug, description, image, sort_order, created_at, modified_at, is_deleted`
+
+// isDuplicateSlug reports whether err is a violation of the unique slug constraint.
+func isDuplicateSlug(err error) bool {
+	return err.Error() == `pq: duplicate key value violates unique constraint "product_category_slug_key"`
+}
+
+func scanCategory(row interface{ Scan(...any) error }, category *Category) error {
+	return row.Scan(&category.ID, &category.ParentId, &category.Name, &category.Slug, &category.Description, &category.Image, &category.SortOrder, &category.CreateAt, &category.ModifiedAt, &category.IsDeleted)
 }
 
 func (m CategoryModel) Insert(category *Category) error {
-	query := `INSERT INTO product_category(name, description)
-	VALUES ($1, $2)
-		RETURNING id`
-	args := []interface{}{category.Name, category.Description}
+	query := `INSERT INTO product_category(parent_id, name, slug, description, image, sort_order)
+	VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, modified_at`
+	args := []interface{}{category.ParentId, category.Name, category.Slug, category.Description, category.Image, category.SortOrder}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.CreateAt, &category.ModifiedAt)
 	if err != nil {
-		return err
+		switch {
+		case isDuplicateSlug(err):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
 	}
+	m.Cache.Invalidate()
 	return nil
 }
 func (m CategoryModel) Get(id uuid.UUID) (*Category, error) {
-	query := `SELECT id, name, description, created_at, modified_at, is_deleted
+	query := `SELECT ` + categoryColumns + `
 	FROM product_category
 	WHERE id = $1`
 	var category Category
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&category.ID, &category.Name, &category.Description, &category.CreateAt, &category.ModifiedAt, &category.IsDeleted)
+	err := scanCategory(m.DB.QueryRowContext(ctx, query, id), &category)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &category, nil
+}
+
+// GetBySlug returns the category with the slug, unless it has been deleted.
+func (m CategoryModel) GetBySlug(slug string) (*Category, error) {
+	query := `SELECT ` + categoryColumns + `
+	FROM product_category
+	WHERE slug = $1 AND is_deleted = false`
+	var category Category
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := scanCategory(m.DB.QueryRowContext(ctx, query, slug), &category)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 	return &category, nil
 }
 func (m CategoryModel) Update(category *Category) error {
 	query := `UPDATE product_category
-	SET name = $1, description = $2, modified_at = $3
-	WHERE id = $4`
-	args := []interface{}{category.Name, category.Description, time.Now(), category.ID}
+	SET parent_id = $1, name = $2, slug = $3, description = $4, image = $5, sort_order = $6, modified_at = $7
+	WHERE id = $8
+	RETURNING modified_at`
+	args := []interface{}{category.ParentId, category.Name, category.Slug, category.Description, category.Image, category.SortOrder, time.Now(), category.ID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ModifiedAt)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case isDuplicateSlug(err):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
 	}
+	m.Cache.Invalidate()
 	return nil
 }
+
+// Delete soft-deletes the category. Its products keep it, but they are no longer
+// found by its slug.
 func (m CategoryModel) Delete(id uuid.UUID) error {
 	query := `UPDATE product_category
-	SET is_deleted = true
-	WHERE id = $1`
+	SET is_deleted = true, modified_at = now()
+	WHERE id = $1 AND is_deleted = false`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	m.Cache.Invalidate()
+	return nil
+}
+
+// Restore undoes Delete.
+func (m CategoryModel) Restore(category *Category) error {
+	query := `UPDATE product_category
+	SET is_deleted = false, modified_at = now()
+	WHERE id = $1 AND is_deleted = true
+	RETURNING modified_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, category.ID).Scan(&category.ModifiedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	category.IsDeleted = false
+	m.Cache.Invalidate()
 	return nil
 }
+
+// HasSubcategories reports whether a category which isn't deleted has the
+// category as its parent.
+func (m CategoryModel) HasSubcategories(id uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM product_category WHERE parent_id = $1 AND is_deleted = false
+	)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&exists)
+	return exists, err
+}
+
+// GetAll returns the categories which aren't deleted as a flat list, ordered by
+// sort order and name. Use CategoryTree to nest them.
 func (m CategoryModel) GetAll() ([]*Category, error) {
-	query := `SELECT id, name, description, created_at, modified_at, is_deleted
+	query := `SELECT ` + categoryColumns + `
+	FROM product_category
+	WHERE is_deleted = false
+	ORDER BY sort_order, name`
+	return cache.Fetch(m.Cache, "categories", "all", func() ([]*Category, error) {
+		return m.list(query)
+	})
+}
+
+// GetAllWithDeleted returns every category, deleted ones included, ordered like
+// GetAll.
+func (m CategoryModel) GetAllWithDeleted() ([]*Category, error) {
+	query := `SELECT ` + categoryColumns + `
 	FROM product_category
-	WHERE is_deleted = false`
+	ORDER BY sort_order, name`
+	return m.list(query)
+}
+
+func (m CategoryModel) list(query string) ([]*Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query)
@@ -86,7 +287,7 @@ func (m CategoryModel) GetAll() ([]*Category, error) {
 	categories := []*Category{}
 	for rows.Next() {
 		category := &Category{}
-		err := rows.Scan(&category.ID, &category.Name, &category.Description, &category.CreateAt, &category.ModifiedAt, &category.IsDeleted)
+		err := scanCategory(rows, category)
 		if err != nil {
 			return nil, err
 		}
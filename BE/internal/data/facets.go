@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"youneon-BE/internal/cache"
+)
+
+// facetTagLimit caps the tag buckets to the most used tags.
+const facetTagLimit = 50
+
+// PriceRange is a bucket of the price facet, To is exclusive and 0 means no upper
+// bound.
+type PriceRange struct {
+	From int `json:"from"`
+	To   int `json:"to,omitempty"`
+}
+
+// PriceRanges are the buckets of the price facet, in VND. Both bounds are
+// inclusive, like the price_from and price_to filters a bucket is selected with.
+var PriceRanges = []PriceRange{
+	{From: 0, To: 499_999},
+	{From: 500_000, To: 999_999},
+	{From: 1_000_000, To: 1_999_999},
+	{From: 2_000_000, To: 4_999_999},
+	{From: 5_000_000},
+}
+
+// FacetBucket is a value of a facet and the number of products which have it.
+// Value is what the product list takes as the filter, Label what to show.
+type FacetBucket struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type PriceBucket struct {
+	PriceRange
+	Count int `json:"count"`
+}
+
+// Facets counts the products matching a filter by category, tag, price range and
+// availability. Each facet applies every filter but its own, so the counts tell
+// how many products selecting a bucket would list, and buckets with no products
+// are left out.
+type Facets struct {
+	Categories   []FacetBucket `json:"categories"`
+	Tags         []FacetBucket `json:"tags"`
+	Prices       []PriceBucket `json:"prices"`
+	Availability []FacetBucket `json:"availability"`
+}
+
+// Facets returns the facets of the products matching the filter. A category
+// counts the products of its subcategories as well; their closure is built with
+// UNION, so a cycle of parents ends the recursion instead of running forever.
+func (m ProductModel) Facets(productFilter ProductFilter) (*Facets, error) {
+	productFilter = productFilter.normalized()
+	return cache.Fetch(m.Cache, "facets", cache.Key(productFilter), func() (*Facets, error) {
+		return m.facets(productFilter)
+	})
+}
+
+func (m ProductModel) facets(productFilter ProductFilter) (*Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := &Facets{}
+	var err error
+	facets.Categories, err = m.facetBuckets(ctx, productFilter, filterCategory, `
+WITH RECURSIVE closure AS (
+    SELECT id AS ancestor_id, id AS descendant_id FROM product_category WHERE is_deleted = false
+    UNION
+    SELECT cl.ancestor_id, c.id FROM closure cl
+    JOIN product_category c ON c.parent_id = cl.descendant_id
+    WHERE c.is_deleted = false
+)
+SELECT a.slug, a.name, count(DISTINCT p.id)
+FROM closure cl
+JOIN product_category a ON a.id = cl.ancestor_id
+JOIN product p ON p.category_id = cl.descendant_id
+WHERE %s
+GROUP BY a.id, a.slug, a.name, a.sort_order
+ORDER BY a.sort_order, a.name`)
+	if err != nil {
+		return nil, err
+	}
+	facets.Tags, err = m.facetBuckets(ctx, productFilter, filterTags, `
+SELECT t.name, t.name, count(*)
+FROM tag_product tp
+JOIN tag t ON t.id = tp.tag_id AND t.is_deleted = false
+JOIN product p ON p.id = tp.product_id
+WHERE %s
+GROUP BY t.name
+ORDER BY count(*) DESC, t.name
+LIMIT `+fmt.Sprint(facetTagLimit))
+	if err != nil {
+		return nil, err
+	}
+	facets.Availability, err = m.facetBuckets(ctx, productFilter, filterAvailability, `
+SELECT s.value, s.value, count(*)
+FROM product p
+CROSS JOIN LATERAL (
+    SELECT CASE WHEN `+inStockCondition+` THEN '`+AvailabilityInStock+`' ELSE '`+AvailabilityOutOfStock+`' END AS value
+) s
+WHERE %s
+GROUP BY s.value
+ORDER BY s.value`)
+	if err != nil {
+		return nil, err
+	}
+	facets.Prices, err = m.priceBuckets(ctx, productFilter)
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// facetBuckets runs a query selecting value, label and count, which has a %s
+// for the filter conditions, leaving out the filter named by skip.
+func (m ProductModel) facetBuckets(ctx context.Context, productFilter ProductFilter, skip string, query string) ([]FacetBucket, error) {
+	args := queryArgs{}
+	query = fmt.Sprintf(query, productFilter.conditions(&args, skip))
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	buckets := []FacetBucket{}
+	for rows.Next() {
+		var bucket FacetBucket
+		err = rows.Scan(&bucket.Value, &bucket.Label, &bucket.Count)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+func (m ProductModel) priceBuckets(ctx context.Context, productFilter ProductFilter) ([]PriceBucket, error) {
+	args := queryArgs{}
+	where := productFilter.conditions(&args, filterPrice)
+	counts := make([]string, len(PriceRanges))
+	for i, r := range PriceRanges {
+		condition := "p.price >= " + args.add(r.From)
+		if r.To != 0 {
+			condition += " AND p.price <= " + args.add(r.To)
+		}
+		counts[i] = fmt.Sprintf("count(*) FILTER (WHERE %s)", condition)
+	}
+	query := fmt.Sprintf(`SELECT %s FROM product p WHERE %s`, strings.Join(counts, ", "), where)
+
+	values := make([]int, len(PriceRanges))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	buckets := []PriceBucket{}
+	for i, r := range PriceRanges {
+		if values[i] > 0 {
+			buckets = append(buckets, PriceBucket{PriceRange: r, Count: values[i]})
+		}
+	}
+	return buckets, nil
+}
@@ -1,16 +1,85 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
 	"math"
 	"strings"
+	"time"
 	"youneon-BE/internal/validator"
 )
 
+// Filters pages through a list either by page number or, when Cursor is set, by
+// a cursor from the metadata of a previous page. Cursors don't count the
+// records, so they stay fast however far the list goes.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorSortColumns are the sort columns which support cursors.
+var cursorSortColumns = []string{"name", "price", "modified_at"}
+
+// pageCursor points just after (or with Before, just before) the record with
+// the sort value Value and the id ID, for the sort Sort.
+type pageCursor struct {
+	Sort   string          `json:"s"`
+	Value  json.RawMessage `json:"v"`
+	ID     uuid.UUID       `json:"id"`
+	Before bool            `json:"b,omitempty"`
+}
+
+func encodeCursor(sort string, value any, id uuid.UUID, before bool) string {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	js, err = json.Marshal(pageCursor{Sort: sort, Value: js, ID: id, Before: before})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c pageCursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Value == nil {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// cursorValue decodes the sort value of the cursor into the Go type of the sort
+// column, so it can be compared with the column in SQL.
+func (c *pageCursor) cursorValue() (any, error) {
+	var err error
+	switch strings.TrimPrefix(c.Sort, "-") {
+	case "name":
+		var v string
+		err = json.Unmarshal(c.Value, &v)
+		return v, err
+	case "price":
+		var v int
+		err = json.Unmarshal(c.Value, &v)
+		return v, err
+	case "modified_at":
+		var v time.Time
+		err = json.Unmarshal(c.Value, &v)
+		return v, err
+	}
+	return nil, errInvalidCursor
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -20,6 +89,21 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.Cursor != "" {
+		v.Check(f.Page == 1, "page", "must not be used with a cursor")
+		v.Check(validator.PermittedValue(strings.TrimPrefix(f.Sort, "-"), cursorSortColumns...), "sort", "can't be used with a cursor")
+		c, err := decodeCursor(f.Cursor)
+		if err == nil {
+			_, err = c.cursorValue()
+		}
+		switch {
+		case err != nil:
+			v.AddError("cursor", "invalid cursor")
+		case c.Sort != f.Sort:
+			v.AddError("cursor", "was made for another sort")
+		}
+	}
 }
 func (f Filters) sortColumn() string {
 	for _, safeValue := range f.SortSafelist {
@@ -42,12 +126,16 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// Metadata describes a page of a list. Pages fetched with a cursor only have the
+// page size and the cursors.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+	"youneon-BE/internal/validator"
+)
+
+type IdempotencyKey struct {
+	Key           string
+	UserId        uuid.UUID
+	RequestMethod string
+	RequestPath   string
+	RequestHash   string
+	StatusCode    *int
+	ResponseBody  []byte
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(key != "", "Idempotency-Key", "must be provided")
+	v.Check(len(key) <= 255, "Idempotency-Key", "must not be more than 255 bytes long")
+}
+
+// Reserve stores a new key before the request is processed. It returns false
+// without touching the stored row when the user already has an unexpired record for
+// the same key; an expired record is overwritten.
+func (m IdempotencyKeyModel) Reserve(key *IdempotencyKey) (bool, error) {
+	query := `
+		INSERT INTO idempotency_keys (key, user_id, request_method, request_path, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_method = EXCLUDED.request_method,
+		    request_path = EXCLUDED.request_path,
+		    request_hash = EXCLUDED.request_hash,
+		    status_code = NULL,
+		    response_body = NULL,
+		    created_at = now(),
+		    expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < now()
+		RETURNING created_at`
+	args := []interface{}{key.Key, key.UserId, key.RequestMethod, key.RequestPath, key.RequestHash, key.ExpiresAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (m IdempotencyKeyModel) Get(userId uuid.UUID, key string) (*IdempotencyKey, error) {
+	query := `
+		SELECT key, user_id, request_method, request_path, request_hash, status_code, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+	var record IdempotencyKey
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userId, key).Scan(
+		&record.Key,
+		&record.UserId,
+		&record.RequestMethod,
+		&record.RequestPath,
+		&record.RequestHash,
+		&record.StatusCode,
+		&record.ResponseBody,
+		&record.CreatedAt,
+		&record.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+func (m IdempotencyKeyModel) SaveResponse(key *IdempotencyKey) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, response_body = $2
+		WHERE user_id = $3 AND key = $4`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, key.StatusCode, key.ResponseBody, key.UserId, key.Key)
+	return err
+}
+
+func (m IdempotencyKeyModel) Delete(userId uuid.UUID, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userId, key)
+	return err
+}
+
+func (m IdempotencyKeyModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at < now()`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OIDCLogin is the state of a social sign-in between the redirect to the provider
+// and the callback. It is looked up by State and can only be used once. UserId is
+// the user who started it while signed in, to link the identity to.
+type OIDCLogin struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserId       *uuid.UUID
+	ExpiresAt    time.Time
+}
+
+type OIDCLoginModel struct {
+	DB *sql.DB
+}
+
+// Insert stores the login, dropping the expired ones of visitors who never came back
+// from the provider.
+func (m OIDCLoginModel) Insert(login *OIDCLogin) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at < NOW()`)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO oidc_logins (state, provider, nonce, code_verifier, user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = m.DB.ExecContext(ctx, query, login.State, login.Provider, login.Nonce, login.CodeVerifier, login.UserId, login.ExpiresAt)
+	return err
+}
+
+// Consume returns and deletes the login with the given state. It returns
+// ErrRecordNotFound when there is none or it has expired.
+func (m OIDCLoginModel) Consume(state string) (*OIDCLogin, error) {
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state = $1
+		RETURNING state, provider, nonce, code_verifier, user_id, expires_at`
+	var login OIDCLogin
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, state).Scan(&login.State, &login.Provider, &login.Nonce, &login.CodeVerifier, &login.UserId, &login.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if time.Now().After(login.ExpiresAt) {
+		return nil, ErrRecordNotFound
+	}
+	return &login, nil
+}
+
+// UserIdentity links an account at an external identity provider to a user.
+type UserIdentity struct {
+	Id        uuid.UUID `json:"id"`
+	UserId    uuid.UUID `json:"-"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserIdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUser returns the user the external identity is linked to.
+func (m UserIdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		SELECT user_id
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2`
+	var userId uuid.UUID
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return UserModel{DB: m.DB}.Get(userId)
+}
+
+func (m UserIdentityModel) Insert(identity *UserIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertIdentity(ctx, m.DB, identity)
+}
+
+// InsertWithUser creates the user and links the identity in one transaction.
+func (m UserIdentityModel) InsertWithUser(user *User, identity *UserIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+	identity.UserId = user.ID
+	err = insertIdentity(ctx, tx, identity)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m UserIdentityModel) GetAllByUserID(id uuid.UUID) ([]*UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*UserIdentity{}
+	for rows.Next() {
+		var identity UserIdentity
+		err := rows.Scan(&identity.Id, &identity.UserId, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func insertIdentity(ctx context.Context, q queryRower, identity *UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	return q.QueryRowContext(ctx, query, identity.UserId, identity.Provider, identity.Subject, identity.Email).Scan(&identity.Id, &identity.CreatedAt)
+}
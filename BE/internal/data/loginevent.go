@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// Reasons a sign-in attempt failed. Only wrong passwords and wrong second factor
+// codes count towards the lockout, so attempts rejected because the account is
+// already locked don't keep extending the lock. LoginReasonSecondFactor marks a
+// correct password waiting for the second factor.
+const (
+	LoginReasonInvalidPassword = "invalid_password"
+	LoginReasonInvalidCode     = "invalid_code"
+	LoginReasonSecondFactor    = "second_factor_required"
+	LoginReasonUnknownEmail    = "unknown_email"
+	LoginReasonLocked          = "locked"
+	LoginReasonThrottled       = "throttled"
+)
+
+// LoginEvent records one sign-in attempt. UserId is nil when the email doesn't
+// belong to any account.
+type LoginEvent struct {
+	Id        uuid.UUID  `json:"id"`
+	UserId    *uuid.UUID `json:"-"`
+	Email     string     `json:"-"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	Success   bool       `json:"success"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginFailures summarises the failed attempts since the last successful sign-in.
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+type LoginEventModel struct {
+	DB *sql.DB
+}
+
+// Insert records the attempt and queues the given emails in one transaction.
+func (m LoginEventModel) Insert(event *LoginEvent, messages ...*EmailMessage) error {
+	query := `
+		INSERT INTO login_events (user_id, email, ip, user_agent, success, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	args := []interface{}{event.UserId, strings.ToLower(event.Email), event.IP, event.UserAgent, event.Success, event.Reason}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&event.Id, &event.CreatedAt)
+	if err != nil {
+		return err
+	}
+	err = insertEmailMessages(ctx, tx, messages)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FailuresByEmail counts the wrong passwords and codes for the email entered after
+// since and after the last successful sign-in.
+func (m LoginEventModel) FailuresByEmail(email string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT count(*), coalesce(max(created_at), 'epoch')
+		FROM login_events
+		WHERE email = $1 AND NOT success AND reason IN ($2, $3) AND created_at > $4
+		AND created_at > coalesce((SELECT max(created_at) FROM login_events WHERE email = $1 AND success), 'epoch')`
+	return m.failures(query, strings.ToLower(email), LoginReasonInvalidPassword, LoginReasonInvalidCode, since)
+}
+
+// FailuresByIP counts the failed password attempts made from the ip after since,
+// whatever account they were for.
+func (m LoginEventModel) FailuresByIP(ip string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT count(*), coalesce(max(created_at), 'epoch')
+		FROM login_events
+		WHERE ip = $1 AND NOT success AND reason IN ($2, $3) AND created_at > $4`
+	return m.failures(query, ip, LoginReasonInvalidPassword, LoginReasonUnknownEmail, since)
+}
+
+func (m LoginEventModel) failures(query string, args ...interface{}) (LoginFailures, error) {
+	var failures LoginFailures
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&failures.Count, &failures.Last)
+	return failures, err
+}
+
+// GetAllByUserID returns the latest sign-in attempts on the account, newest first.
+// A limit of 0 returns all of them.
+func (m LoginEventModel) GetAllByUserID(id uuid.UUID, limit int) ([]*LoginEvent, error) {
+	query := `
+		SELECT id, user_id, email, ip, user_agent, success, reason, created_at
+		FROM login_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT NULLIF($2, 0)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*LoginEvent{}
+	for rows.Next() {
+		var event LoginEvent
+		err := rows.Scan(
+			&event.Id,
+			&event.UserId,
+			&event.Email,
+			&event.IP,
+			&event.UserAgent,
+			&event.Success,
+			&event.Reason,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LocaleVietnamese = "vi"
+	LocaleEnglish    = "en"
+	// FallbackLocale is used when a template doesn't exist in the requested locale.
+	FallbackLocale = LocaleEnglish
+)
+
+var Locales = []string{LocaleVietnamese, LocaleEnglish}
+
+// NormalizeLocale turns values like "vi-VN" or "EN" into one of Locales, falling
+// back to FallbackLocale for anything else.
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(locale)
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	for _, l := range Locales {
+		if locale == l {
+			return l
+		}
+	}
+	return FallbackLocale
+}
+
+// templatePath returns the path of templateFile for locale inside templateFS and
+// the locale it is written in, falling back to the FallbackLocale version.
+func templatePath(locale, templateFile string) (string, string, error) {
+	for _, l := range []string{NormalizeLocale(locale), FallbackLocale} {
+		path := "templates/" + l + "/" + templateFile
+		if _, err := fs.Stat(templateFS, path); err == nil {
+			return path, l, nil
+		}
+	}
+	return "", "", fmt.Errorf("mailer: template %q not found", templateFile)
+}
+
+// Templates lists the template files available in the fallback locale.
+func Templates() ([]string, error) {
+	entries, err := fs.ReadDir(templateFS, "templates/"+FallbackLocale)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	return files, nil
+}
+
+// templateFuncs returns the helpers available inside templates for a locale:
+//
+//	{{currency .Total}}  1.500.000 ₫ (vi) or 1,500,000 VND (en)
+//	{{date .Date}}       19/10/2026 (vi) or Oct 19, 2026 (en)
+func templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"currency": func(amount any) (string, error) {
+			n, err := toInt64(amount)
+			if err != nil {
+				return "", err
+			}
+			if locale == LocaleVietnamese {
+				return groupThousands(n, ".") + " ₫", nil
+			}
+			return groupThousands(n, ",") + " VND", nil
+		},
+		"date": func(value any) (string, error) {
+			t, err := toTime(value)
+			if err != nil {
+				return "", err
+			}
+			if locale == LocaleVietnamese {
+				return t.Format("02/01/2006"), nil
+			}
+			return t.Format("Jan 2, 2006"), nil
+		},
+		"datetime": func(value any) (string, error) {
+			t, err := toTime(value)
+			if err != nil {
+				return "", err
+			}
+			if locale == LocaleVietnamese {
+				return t.Format("15:04 02/01/2006"), nil
+			}
+			return t.Format("Jan 2, 2006 at 15:04 MST"), nil
+		},
+	}
+}
+
+func groupThousands(n int64, sep string) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	digits := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// Template data may come straight from Go values or from JSON stored in the email
+// outbox, so the helpers accept both representations.
+func toInt64(value any) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case json.Number:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("mailer: cannot format %T as currency", value)
+	}
+}
+
+func toTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339, v)
+	default:
+		return time.Time{}, fmt.Errorf("mailer: cannot format %T as date", value)
+	}
+}
@@ -3,7 +3,6 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"github.com/go-mail/mail/v2"
 	"html/template"
 	"time"
 )
@@ -17,80 +16,81 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
+// Define a Mailer struct which contains the Transport used to deliver the rendered
+// messages and the sender information for your emails (the name and address you
 // want the email to be from, such as "Alice Smith <alice@example.com>")
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	sender    string
 }
 
+// New returns a Mailer which delivers through the given SMTP server.
 func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-	// Return a Mailer instance containing the dialer and sender information.
+	return NewWithTransport(NewSMTPTransport(host, port, username, password), sender)
+}
+
+// NewWithTransport returns a Mailer which hands the rendered messages to transport,
+// e.g. a FileTransport or MemoryTransport during development and tests.
+func NewWithTransport(transport Transport, sender string) Mailer {
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		transport: transport,
+		sender:    sender,
 	}
 }
 
 // Define a Send() method on the Mailer type. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// as the first parameter, the recipient's locale ("vi", "en"), the name of the file
+// containing the templates, and any dynamic data for the templates as an any parameter.
+func (m Mailer) Send(recipient, locale, templateFile string, data any) error {
+	msg, err := m.Render(recipient, locale, templateFile, data)
 	if err != nil {
 		return err
 	}
+	// Retrying is left to the caller (the email outbox workers), so a slow SMTP
+	// server never holds up an HTTP request.
+	return m.transport.Send(msg)
+}
+
+// Render executes the "subject", "plainBody" and "htmlBody" templates of the given
+// file and returns the resulting message without sending it. The template is looked
+// up in templates/<locale>/ first and templates/en/ otherwise.
+func (m Mailer) Render(recipient, locale, templateFile string, data any) (*Message, error) {
+	path, locale, err := templatePath(locale, templateFile)
+	if err != nil {
+		return nil, err
+	}
+	// Use the ParseFS() method to parse the required template file from the embedded
+	// file system, with the formatting helpers for the locale.
+	tmpl, err := template.New("email").Funcs(templateFuncs(locale)).ParseFS(templateFS, path)
+	if err != nil {
+		return nil, err
+	}
 	// Execute the named template "subject", passing in the dynamic data and storing the
 	// result in a bytes.Buffer variable.
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Follow the same pattern to execute the "plainBody" template and store the result
 	// in the plainBody variable.
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
-	}
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the email recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that AddAlternative() should
-	// always be called *after* SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error.
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		// If everything worked, return nil.
-		if nil == err {
-			return nil
-		}
-		// If it didn't work, sleep for a short time and retry.
-		time.Sleep(500 * time.Millisecond)
+		return nil, err
 	}
-	return err
-
+	return &Message{
+		To:        recipient,
+		From:      m.sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+		Date:      time.Now(),
+	}, nil
 }
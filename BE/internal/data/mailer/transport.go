@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/go-mail/mail/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a rendered email, ready to be handed to a Transport.
+type Message struct {
+	To        string    `json:"to"`
+	From      string    `json:"from"`
+	Subject   string    `json:"subject"`
+	PlainBody string    `json:"plain_body"`
+	HTMLBody  string    `json:"html_body"`
+	Date      time.Time `json:"date"`
+}
+
+// mailMessage converts the message to a go-mail message. Note that AddAlternative()
+// must always be called *after* SetBody().
+func (msg *Message) mailMessage() *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetDateHeader("Date", msg.Date)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
+
+// Transport delivers rendered messages.
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// SMTPTransport sends messages through an SMTP server.
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	// We configure the dialer to use a 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+	return &SMTPTransport{dialer: dialer}
+}
+
+// Send opens a connection to the SMTP server, sends the message, then closes the
+// connection. If there is a timeout, it will return a "dial tcp: i/o timeout" error.
+func (t *SMTPTransport) Send(msg *Message) error {
+	return t.dialer.DialAndSend(msg.mailMessage())
+}
+
+// FileTransport writes every message as an .eml file to a directory, which can be
+// opened with any mail client.
+type FileTransport struct {
+	Dir string
+}
+
+func (t *FileTransport) Send(msg *Message) error {
+	err := os.MkdirAll(t.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", msg.Date.Format("20060102T150405.000000000"), recipient)
+	f, err := os.Create(filepath.Join(t.Dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = msg.mailMessage().WriteTo(f)
+	return err
+}
+
+// MemoryTransport keeps the last Limit messages in memory so tests and the
+// /debug/mail page can look at them.
+type MemoryTransport struct {
+	Limit    int
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (t *MemoryTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, *msg)
+	if t.Limit > 0 && len(t.messages) > t.Limit {
+		t.messages = t.messages[len(t.messages)-t.Limit:]
+	}
+	return nil
+}
+
+// Messages returns a copy of the captured messages, oldest first.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.messages...)
+}
+
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = nil
+}
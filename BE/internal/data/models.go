@@ -4,12 +4,18 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/google/uuid"
+	"time"
+	"youneon-BE/internal/cache"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
 	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrDuplicateName  = errors.New("duplicate name")
+	ErrDuplicateSlug  = errors.New("duplicate slug")
+	ErrReturnQuantity = errors.New("return quantity exceeds the quantity left")
+	ErrRefundAmount   = errors.New("refund amount exceeds the amount left")
 )
 
 type Models struct {
@@ -18,20 +24,30 @@ type Models struct {
 		GetByEmail(email string) (*User, error)
 		Update(profile *User) error
 		Get(id uuid.UUID) (*User, error)
+		Anonymize(user *User) error
 	}
 	Products interface {
 		Insert(product *Product) error
 		Get(id uuid.UUID) (*Product, error)
+		GetByName(name string) (*Product, error)
 		Update(product *Product) error
 		Delete(id uuid.UUID) error
-		GetAll(filters Filters, category string, tags []string, name string, priceFrom int, priceTo int) ([]*Product, Metadata, error)
+		GetAll(filters Filters, productFilter ProductFilter) ([]*Product, Metadata, error)
+		Facets(productFilter ProductFilter) (*Facets, error)
+		Suggest(search string, limit int) ([]*ProductSuggestion, error)
+		ExistingSKUs(skus []string) (map[string]bool, error)
+		Import(imports []*ProductImport) error
 	}
 	Categories interface {
 		Insert(category *Category) error
 		Get(id uuid.UUID) (*Category, error)
+		GetBySlug(slug string) (*Category, error)
 		Update(category *Category) error
 		Delete(id uuid.UUID) error
 		GetAll() ([]*Category, error)
+		GetAllWithDeleted() ([]*Category, error)
+		Restore(category *Category) error
+		HasSubcategories(id uuid.UUID) (bool, error)
 	}
 	Tags interface {
 		Insert(tag *Tag) error
@@ -39,7 +55,12 @@ type Models struct {
 		Update(tag *Tag) error
 		Delete(id uuid.UUID) error
 		GetAll() ([]*Tag, error)
+		GetAllWithDeleted() ([]*Tag, error)
 		GetAllTagByProductID(id uuid.UUID) ([]*Tag, error)
+		AddToProduct(tagId uuid.UUID, productId uuid.UUID) error
+		RemoveFromProduct(tagId uuid.UUID, productId uuid.UUID) error
+		Restore(tag *Tag) error
+		Merge(sourceId uuid.UUID, targetId uuid.UUID) error
 	}
 	CartItems interface {
 		Insert(cartItem *CartItem) error
@@ -58,13 +79,84 @@ type Models struct {
 	OrderDetail interface {
 		Insert(orderDetail *OrderDetail) (*uuid.UUID, error)
 		GetAllByUserID(id uuid.UUID) ([]*OrderDetail, error)
-		Update(orderDetail *OrderDetail) error
+		Update(orderDetail *OrderDetail, fromStatus string, messages ...*EmailMessage) error
+		Create(orderDetail *OrderDetail, items []*OrderItem, messages ...*EmailMessage) error
 		Delete(id uuid.UUID) error
 		GetById(id uuid.UUID) (*OrderDetail, error)
 	}
 	OrderItem interface {
 		Insert(orderItem *OrderItem) (*uuid.UUID, error)
 		GetAllByOrderID(id uuid.UUID) ([]*OrderItem, error)
+		GetById(id uuid.UUID) (*OrderItem, error)
+	}
+	Payments interface {
+		Insert(payment *Payment) error
+		GetByTxnRef(txnRef string) (*Payment, error)
+		GetAllByOrderID(id uuid.UUID) ([]*Payment, error)
+		Finalize(payment *Payment, orderStatus string, messages ...*EmailMessage) error
+	}
+	ReturnRequests interface {
+		Insert(ret *ReturnRequest) error
+		Get(id uuid.UUID) (*ReturnRequest, error)
+		GetAllByUserID(id uuid.UUID) ([]*ReturnRequest, error)
+		GetAll(status string) ([]*ReturnRequest, error)
+		Approve(ret *ReturnRequest, productId uuid.UUID, refund *Refund) error
+		Reject(ret *ReturnRequest) error
+	}
+	Refunds interface {
+		Get(id uuid.UUID) (*Refund, error)
+		GetAllByOrderID(id uuid.UUID) ([]*Refund, error)
+		RefundedAmount(orderId uuid.UUID) (int, error)
+		UpdateStatus(refund *Refund) error
+	}
+	EmailOutbox interface {
+		Insert(messages ...*EmailMessage) error
+		Claim(limit int, lease time.Duration) ([]*EmailMessage, error)
+		MarkSent(id uuid.UUID) error
+		MarkFailed(id uuid.UUID, sendErr error, nextAttempt time.Time, dead bool) error
+		GetAll(status string, filters Filters) ([]*EmailMessage, Metadata, error)
+		Get(id uuid.UUID) (*EmailMessage, error)
+		Retry(id uuid.UUID) (*EmailMessage, error)
+	}
+	IdempotencyKeys interface {
+		Reserve(key *IdempotencyKey) (bool, error)
+		Get(userId uuid.UUID, key string) (*IdempotencyKey, error)
+		SaveResponse(key *IdempotencyKey) error
+		Delete(userId uuid.UUID, key string) error
+		DeleteExpired() (int64, error)
+	}
+	LoginEvents interface {
+		Insert(event *LoginEvent, messages ...*EmailMessage) error
+		FailuresByEmail(email string, since time.Time) (LoginFailures, error)
+		FailuresByIP(ip string, since time.Time) (LoginFailures, error)
+		GetAllByUserID(id uuid.UUID, limit int) ([]*LoginEvent, error)
+	}
+	TwoFactor interface {
+		Get(userId uuid.UUID) (*TwoFactor, error)
+		Enroll(tf *TwoFactor) error
+		Enable(tf *TwoFactor, counter int64, recoveryHashes [][]byte) error
+		UseCounter(userId uuid.UUID, counter int64) error
+		UseRecoveryCode(userId uuid.UUID, hash []byte) error
+		ReplaceRecoveryCodes(userId uuid.UUID, hashes [][]byte) error
+		RecoveryCodesLeft(userId uuid.UUID) (int, error)
+		Delete(userId uuid.UUID) error
+	}
+	OIDCLogins interface {
+		Insert(login *OIDCLogin) error
+		Consume(state string) (*OIDCLogin, error)
+	}
+	UserIdentities interface {
+		GetUser(provider, subject string) (*User, error)
+		Insert(identity *UserIdentity) error
+		InsertWithUser(user *User, identity *UserIdentity) error
+		GetAllByUserID(id uuid.UUID) ([]*UserIdentity, error)
+	}
+	APIKeys interface {
+		Insert(key *APIKey) error
+		GetByHash(hash []byte) (*APIKey, error)
+		GetAllByUserID(id uuid.UUID) ([]*APIKey, error)
+		Revoke(id uuid.UUID, userId uuid.UUID) error
+		TouchLastUsed(id uuid.UUID) error
 	}
 	Shortener interface {
 		CreateShortener(longURL string, shortURL string) (Shortener, error)
@@ -73,16 +165,28 @@ type Models struct {
 	}
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the models, the catalogue ones caching their reads in
+// catalogue unless it is nil.
+func NewModels(db *sql.DB, catalogue *cache.Cache) Models {
 	return Models{
-		Users:       UserModel{DB: db},
-		Products:    ProductModel{DB: db},
-		Categories:  CategoryModel{DB: db},
-		Tags:        TagModel{DB: db},
-		CartItems:   CartItemModel{DB: db},
-		Address:     AddressModel{DB: db},
-		OrderDetail: OrderDetailModel{DB: db},
-		OrderItem:   OrderItemModel{DB: db},
-		Shortener:   ShortenerModel{db: db},
+		Users:           UserModel{DB: db},
+		Products:        ProductModel{DB: db, Cache: catalogue},
+		Categories:      CategoryModel{DB: db, Cache: catalogue},
+		Tags:            TagModel{DB: db, Cache: catalogue},
+		CartItems:       CartItemModel{DB: db},
+		Address:         AddressModel{DB: db},
+		OrderDetail:     OrderDetailModel{DB: db},
+		OrderItem:       OrderItemModel{DB: db},
+		Payments:        PaymentModel{DB: db},
+		ReturnRequests:  ReturnRequestModel{DB: db, Cache: catalogue},
+		Refunds:         RefundModel{DB: db},
+		EmailOutbox:     EmailOutboxModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		LoginEvents:     LoginEventModel{DB: db},
+		TwoFactor:       TwoFactorModel{DB: db},
+		OIDCLogins:      OIDCLoginModel{DB: db},
+		UserIdentities:  UserIdentityModel{DB: db},
+		APIKeys:         APIKeyModel{DB: db},
+		Shortener:       ShortenerModel{db: db},
 	}
 }
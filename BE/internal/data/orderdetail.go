@@ -6,14 +6,37 @@ import (
 	"errors"
 	"github.com/google/uuid"
 	"time"
+	"youneon-BE/internal/validator"
 )
 
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusConfirmed = "confirmed"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusConfirmed, OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusConfirmed: {OrderStatusPaid, OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:   {OrderStatusDelivered},
+}
+
 type OrderDetail struct {
-	Id            uuid.UUID `json:"id"`
-	UserId        uuid.UUID `json:"user_id"`
-	Total         int       `json:"total"`
-	AddressDetail string    `json:"address_detail"`
-	Status        string    `json:"status"`
+	Id             uuid.UUID `json:"id"`
+	UserId         uuid.UUID `json:"user_id"`
+	Total          int       `json:"total"`
+	AddressDetail  string    `json:"address_detail"`
+	Status         string    `json:"status"`
+	TrackingNumber *string   `json:"tracking_number"`
+}
+
+func (o *OrderDetail) CanTransitionTo(status string) bool {
+	return validator.PermittedValue(status, orderTransitions[o.Status]...)
 }
 
 type OrderDetailModel struct {
@@ -32,7 +55,7 @@ func (m OrderDetailModel) Insert(orderDetail *OrderDetail) (*uuid.UUID, error) {
 	return &id, nil
 }
 func (m OrderDetailModel) GetAllByUserID(id uuid.UUID) ([]*OrderDetail, error) {
-	query := `SELECT id, user_id, total, address_detail, status FROM order_details WHERE user_id = $1`
+	query := `SELECT id, user_id, total, address_detail, status, tracking_number FROM order_details WHERE user_id = $1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, id)
@@ -48,7 +71,7 @@ func (m OrderDetailModel) GetAllByUserID(id uuid.UUID) ([]*OrderDetail, error) {
 	var orderDetails []*OrderDetail
 	for rows.Next() {
 		var orderDetail OrderDetail
-		err := rows.Scan(&orderDetail.Id, &orderDetail.UserId, &orderDetail.Total, &orderDetail.AddressDetail, &orderDetail.Status)
+		err := rows.Scan(&orderDetail.Id, &orderDetail.UserId, &orderDetail.Total, &orderDetail.AddressDetail, &orderDetail.Status, &orderDetail.TrackingNumber)
 		if err != nil {
 			return nil, err
 		}
@@ -59,15 +82,78 @@ func (m OrderDetailModel) GetAllByUserID(id uuid.UUID) ([]*OrderDetail, error) {
 	}
 	return orderDetails, nil
 }
-func (m OrderDetailModel) Update(orderDetail *OrderDetail) error {
-	query := `UPDATE order_details SET total = $1, address_detail = $2, status = $3 WHERE id = $4`
+
+// Update saves the order and queues the given emails in one transaction. The order
+// is only saved while its status is still fromStatus, the status its transition was
+// checked against, so concurrent updates get ErrEditConflict instead of skipping
+// the transition rules.
+func (m OrderDetailModel) Update(orderDetail *OrderDetail, fromStatus string, messages ...*EmailMessage) error {
+	query := `
+		UPDATE order_details SET total = $1, address_detail = $2, status = $3, tracking_number = $4
+		WHERE id = $5 AND status = $6`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, orderDetail.Total, orderDetail.AddressDetail, orderDetail.Status, orderDetail.Id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, query, orderDetail.Total, orderDetail.AddressDetail, orderDetail.Status, orderDetail.TrackingNumber, orderDetail.Id, fromStatus)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	err = insertEmailMessages(ctx, tx, messages)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Create places an order: it inserts the order and its items, empties the cart of
+// the ordered products and queues the given emails in a single transaction. The
+// order id is generated up front so the emails can reference it.
+func (m OrderDetailModel) Create(orderDetail *OrderDetail, items []*OrderItem, messages ...*EmailMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if orderDetail.Id == uuid.Nil {
+		orderDetail.Id = uuid.New()
+	}
+	query := `INSERT INTO order_details (id, user_id, total, address_detail, status) VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, orderDetail.Id, orderDetail.UserId, orderDetail.Total, orderDetail.AddressDetail, orderDetail.Status)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		item.OrderID = orderDetail.Id
+		query = `INSERT INTO order_items (order_id, product_id, quantity, price) VALUES ($1, $2, $3, $4) RETURNING id`
+		err = tx.QueryRowContext(ctx, query, item.OrderID, item.ProductID, item.Quantity, item.Price).Scan(&item.ID)
+		if err != nil {
+			return err
+		}
+		query = `DELETE FROM cart_item WHERE user_id = $1 AND product_id = $2`
+		_, err = tx.ExecContext(ctx, query, orderDetail.UserId, item.ProductID)
+		if err != nil {
+			return err
+		}
+	}
+	err = insertEmailMessages(ctx, tx, messages)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 func (m OrderDetailModel) Delete(id uuid.UUID) error {
 	query := `DELETE FROM order_details WHERE id = $1`
@@ -80,12 +166,12 @@ func (m OrderDetailModel) Delete(id uuid.UUID) error {
 	return nil
 }
 func (m OrderDetailModel) GetById(id uuid.UUID) (*OrderDetail, error) {
-	query := `SELECT id, user_id, total, address_detail, status FROM order_details WHERE id = $1`
+	query := `SELECT id, user_id, total, address_detail, status, tracking_number FROM order_details WHERE id = $1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	var orderDetail OrderDetail
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&orderDetail.Id, &orderDetail.UserId, &orderDetail.Total, &orderDetail.AddressDetail, &orderDetail.Status)
+	err := row.Scan(&orderDetail.Id, &orderDetail.UserId, &orderDetail.Total, &orderDetail.AddressDetail, &orderDetail.Status, &orderDetail.TrackingNumber)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/google/uuid"
 	"time"
 )
@@ -12,6 +13,7 @@ type OrderItem struct {
 	OrderID   uuid.UUID `json:"order_id"`
 	ProductID uuid.UUID `json:"product_id"`
 	Quantity  int       `json:"quantity"`
+	Price     int       `json:"price"` // unit price at the time of purchase
 }
 
 type OrderItemModel struct {
@@ -19,11 +21,11 @@ type OrderItemModel struct {
 }
 
 func (m OrderItemModel) Insert(orderItem *OrderItem) (*uuid.UUID, error) {
-	query := `INSERT INTO order_items (order_id, product_id, quantity) VALUES ($1, $2, $3) returning id`
+	query := `INSERT INTO order_items (order_id, product_id, quantity, price) VALUES ($1, $2, $3, $4) returning id`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	var id *uuid.UUID
-	err := m.DB.QueryRowContext(ctx, query, orderItem.OrderID, orderItem.ProductID, orderItem.Quantity).Scan(&id)
+	err := m.DB.QueryRowContext(ctx, query, orderItem.OrderID, orderItem.ProductID, orderItem.Quantity, orderItem.Price).Scan(&id)
 	if err != nil {
 		return &uuid.Nil, err
 	}
@@ -31,7 +33,7 @@ func (m OrderItemModel) Insert(orderItem *OrderItem) (*uuid.UUID, error) {
 }
 
 func (m OrderItemModel) GetAllByOrderID(id uuid.UUID) ([]*OrderItem, error) {
-	query := `SELECT id, order_id, product_id, quantity FROM order_items WHERE order_id = $1`
+	query := `SELECT id, order_id, product_id, quantity, price FROM order_items WHERE order_id = $1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, id)
@@ -47,7 +49,7 @@ func (m OrderItemModel) GetAllByOrderID(id uuid.UUID) ([]*OrderItem, error) {
 	var orderItems []*OrderItem
 	for rows.Next() {
 		var orderItem OrderItem
-		err := rows.Scan(&orderItem.ID, &orderItem.OrderID, &orderItem.ProductID, &orderItem.Quantity)
+		err := rows.Scan(&orderItem.ID, &orderItem.OrderID, &orderItem.ProductID, &orderItem.Quantity, &orderItem.Price)
 		if err != nil {
 			return nil, err
 		}
@@ -58,3 +60,20 @@ func (m OrderItemModel) GetAllByOrderID(id uuid.UUID) ([]*OrderItem, error) {
 	}
 	return orderItems, nil
 }
+
+func (m OrderItemModel) GetById(id uuid.UUID) (*OrderItem, error) {
+	query := `SELECT id, order_id, product_id, quantity, price FROM order_items WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var orderItem OrderItem
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&orderItem.ID, &orderItem.OrderID, &orderItem.ProductID, &orderItem.Quantity, &orderItem.Price)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &orderItem, nil
+}
@@ -0,0 +1,247 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSending = "sending"
+	EmailStatusSent    = "sent"
+	EmailStatusDead    = "dead"
+)
+
+// EmailMessage is a row of the email outbox. Data holds the template data as JSON
+// so the message can be rendered by a worker long after the request that queued it.
+type EmailMessage struct {
+	Id            uuid.UUID       `json:"id"`
+	Recipient     string          `json:"recipient"`
+	Locale        string          `json:"locale"`
+	Template      string          `json:"template"`
+	Data          json.RawMessage `json:"data"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     *string         `json:"last_error"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	SentAt        *time.Time      `json:"sent_at"`
+}
+
+func NewEmailMessage(recipient, locale, template string, data any) (*EmailMessage, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailMessage{Recipient: recipient, Locale: locale, Template: template, Data: js, Status: EmailStatusPending}, nil
+}
+
+type EmailOutboxModel struct {
+	DB *sql.DB
+}
+
+// insertEmailMessages writes messages to the outbox inside an existing transaction,
+// so an email is queued if and only if the change that triggered it is committed.
+// Nil messages are skipped.
+func insertEmailMessages(ctx context.Context, tx *sql.Tx, messages []*EmailMessage) error {
+	query := `
+		INSERT INTO email_outbox (recipient, locale, template, data)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, next_attempt_at, created_at`
+	for _, msg := range messages {
+		if msg == nil {
+			continue
+		}
+		err := tx.QueryRowContext(ctx, query, msg.Recipient, msg.Locale, msg.Template, []byte(msg.Data)).Scan(&msg.Id, &msg.Status, &msg.NextAttemptAt, &msg.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m EmailOutboxModel) Insert(messages ...*EmailMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = insertEmailMessages(ctx, tx, messages)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const emailMessageColumns = `id, recipient, locale, template, data, status, attempts, last_error, next_attempt_at, created_at, sent_at`
+
+func scanEmailMessage(row interface{ Scan(...any) error }, msg *EmailMessage) error {
+	return row.Scan(
+		&msg.Id,
+		&msg.Recipient,
+		&msg.Locale,
+		&msg.Template,
+		&msg.Data,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.NextAttemptAt,
+		&msg.CreatedAt,
+		&msg.SentAt,
+	)
+}
+
+// Claim locks up to limit messages which are due for delivery and marks them as
+// sending. Messages left in "sending" by a crashed process are picked up again once
+// their lease has expired.
+func (m EmailOutboxModel) Claim(limit int, lease time.Duration) ([]*EmailMessage, error) {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sending', attempts = attempts + 1, locked_until = now() + $2 * interval '1 second'
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE (status = 'pending' AND next_attempt_at <= now())
+			   OR (status = 'sending' AND locked_until < now())
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + emailMessageColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := []*EmailMessage{}
+	for rows.Next() {
+		var msg EmailMessage
+		err := scanEmailMessage(rows, &msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (m EmailOutboxModel) MarkSent(id uuid.UUID) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sent', sent_at = now(), last_error = NULL, locked_until = NULL
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed records a failed attempt. The message is retried at nextAttempt, or
+// moved to the dead state when dead is true.
+func (m EmailOutboxModel) MarkFailed(id uuid.UUID, sendErr error, nextAttempt time.Time, dead bool) error {
+	status := EmailStatusPending
+	if dead {
+		status = EmailStatusDead
+	}
+	query := `
+		UPDATE email_outbox
+		SET status = $1, last_error = $2, next_attempt_at = $3, locked_until = NULL
+		WHERE id = $4`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, status, sendErr.Error(), nextAttempt, id)
+	return err
+}
+
+func (m EmailOutboxModel) GetAll(status string, filters Filters) ([]*EmailMessage, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + emailMessageColumns + `
+		FROM email_outbox
+		WHERE (status = $1 OR $1 = '')
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	messages := []*EmailMessage{}
+	for rows.Next() {
+		var msg EmailMessage
+		err := rows.Scan(
+			&totalRecords,
+			&msg.Id,
+			&msg.Recipient,
+			&msg.Locale,
+			&msg.Template,
+			&msg.Data,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.NextAttemptAt,
+			&msg.CreatedAt,
+			&msg.SentAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return messages, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m EmailOutboxModel) Get(id uuid.UUID) (*EmailMessage, error) {
+	query := `SELECT ` + emailMessageColumns + ` FROM email_outbox WHERE id = $1`
+	var msg EmailMessage
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := scanEmailMessage(m.DB.QueryRowContext(ctx, query, id), &msg)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &msg, nil
+}
+
+// Retry puts a dead or pending message back in the queue for immediate delivery
+// with a fresh attempt budget.
+func (m EmailOutboxModel) Retry(id uuid.UUID) (*EmailMessage, error) {
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = now(), locked_until = NULL
+		WHERE id = $1 AND status IN ('dead', 'pending')
+		RETURNING ` + emailMessageColumns
+	var msg EmailMessage
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := scanEmailMessage(m.DB.QueryRowContext(ctx, query, id), &msg)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+	return &msg, nil
+}
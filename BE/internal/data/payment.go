@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusSucceeded = "succeeded"
+	PaymentStatusFailed    = "failed"
+)
+
+type Payment struct {
+	Id            uuid.UUID `json:"id"`
+	OrderId       uuid.UUID `json:"order_id"`
+	Provider      string    `json:"provider"`
+	Amount        int       `json:"amount"`
+	Status        string    `json:"status"`
+	TxnRef        string    `json:"txn_ref"`
+	ProviderTxnId *string   `json:"provider_txn_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	ModifiedAt    time.Time `json:"modified_at"`
+}
+
+type PaymentModel struct {
+	DB *sql.DB
+}
+
+func (m PaymentModel) Insert(payment *Payment) error {
+	query := `
+		INSERT INTO payments (order_id, provider, amount, status, txn_ref)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, modified_at`
+	args := []interface{}{payment.OrderId, payment.Provider, payment.Amount, payment.Status, payment.TxnRef}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&payment.Id, &payment.CreatedAt, &payment.ModifiedAt)
+}
+
+func (m PaymentModel) GetByTxnRef(txnRef string) (*Payment, error) {
+	query := `
+		SELECT id, order_id, provider, amount, status, txn_ref, provider_txn_id, created_at, modified_at
+		FROM payments
+		WHERE txn_ref = $1`
+	var payment Payment
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, txnRef).Scan(
+		&payment.Id,
+		&payment.OrderId,
+		&payment.Provider,
+		&payment.Amount,
+		&payment.Status,
+		&payment.TxnRef,
+		&payment.ProviderTxnId,
+		&payment.CreatedAt,
+		&payment.ModifiedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &payment, nil
+}
+
+func (m PaymentModel) GetAllByOrderID(id uuid.UUID) ([]*Payment, error) {
+	query := `
+		SELECT id, order_id, provider, amount, status, txn_ref, provider_txn_id, created_at, modified_at
+		FROM payments
+		WHERE order_id = $1
+		ORDER BY created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	payments := []*Payment{}
+	for rows.Next() {
+		var payment Payment
+		err := rows.Scan(
+			&payment.Id,
+			&payment.OrderId,
+			&payment.Provider,
+			&payment.Amount,
+			&payment.Status,
+			&payment.TxnRef,
+			&payment.ProviderTxnId,
+			&payment.CreatedAt,
+			&payment.ModifiedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, &payment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// Finalize moves a pending payment to its final status, sets the status of the
+// owning order and queues the given emails in a single transaction. Only pending
+// payments are updated, so a gateway delivering the same callback twice gets
+// ErrEditConflict on the second call instead of flipping the order state again.
+// The order is locked and only moved when its status allows it: a payment which
+// succeeds after the order was cancelled, or already paid, is kept and a refund of
+// it is queued instead, without the emails, which are about the order moving.
+func (m PaymentModel) Finalize(payment *Payment, orderStatus string, messages ...*EmailMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE payments
+		SET status = $1, provider_txn_id = $2, modified_at = now()
+		WHERE id = $3 AND status = 'pending'
+		RETURNING modified_at`
+	err = tx.QueryRowContext(ctx, query, payment.Status, payment.ProviderTxnId, payment.Id).Scan(&payment.ModifiedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	if orderStatus != "" {
+		order := OrderDetail{Id: payment.OrderId}
+		err = tx.QueryRowContext(ctx, `SELECT status FROM order_details WHERE id = $1 FOR UPDATE`, order.Id).Scan(&order.Status)
+		if err != nil {
+			return err
+		}
+		if order.CanTransitionTo(orderStatus) {
+			_, err = tx.ExecContext(ctx, `UPDATE order_details SET status = $1 WHERE id = $2`, orderStatus, order.Id)
+		} else if payment.Status == PaymentStatusSucceeded {
+			messages = nil
+			err = insertRefund(ctx, tx, &Refund{
+				OrderId:   order.Id,
+				PaymentId: &payment.Id,
+				Amount:    payment.Amount,
+				Status:    RefundStatusPending,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = insertEmailMessages(ctx, tx, messages)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -3,15 +3,20 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"slices"
+	"strings"
 	"time"
+	"youneon-BE/internal/cache"
 	"youneon-BE/internal/validator"
 )
 
 type Product struct {
 	Id          uuid.UUID `json:"id"`
+	Sku         *string   `json:"sku"`
 	Name        string    `json:"name"`
 	Price       int       `json:"price"`
 	Image       *string   `json:"image"`
@@ -26,8 +31,19 @@ type Product struct {
 	Tags        []string  `json:"tags"` //Not in DB
 }
 
+// ProductModel caches the product list, facets, suggestions and products in
+// Cache, when set, until the catalogue changes.
 type ProductModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *cache.Cache
+}
+
+// setImageList stores the scanned image_list column, which is NULL when the product
+// has no gallery.
+func (p *Product) setImageList(images []string) {
+	if images != nil {
+		p.ImageList = &images
+	}
 }
 
 func ValidateProduct(v *validator.Validator, product *Product) {
@@ -38,20 +54,193 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 }
 
 func (m ProductModel) Insert(product *Product) error {
-	query := `INSERT INTO product (name, price, image, image_list, description, category_id, inventory_id, discount_id)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	query := `INSERT INTO product (sku, name, price, image, image_list, description, category_id, inventory_id, discount_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id, created_at, modified_at`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, product.Name, product.Price, product.Image, pq.Array(product.ImageList), product.Description, product.CategoryId, product.InventoryId, product.DiscountId)
+	err := m.DB.QueryRowContext(ctx, query, product.Sku, product.Name, product.Price, product.Image, pq.Array(product.ImageList), product.Description, product.CategoryId, product.InventoryId, product.DiscountId).Scan(&product.Id, &product.CreatedAt, &product.ModifiedAt)
 	if err != nil {
 		return err
 	}
+	m.Cache.Invalidate()
 	return nil
 }
-func (m ProductModel) GetAll(filters Filters, category string, tags []string, name string, priceFrom int, priceTo int) ([]*Product, Metadata, error) {
+
+// ProductFilter holds the filters of the product list. An empty or zero field
+// doesn't filter.
+type ProductFilter struct {
+	Category     string   // Slug or name, subcategories included
+	Tags         []string // Products with any of the tags
+	Name         string   // Full-text search
+	PriceFrom    int
+	PriceTo      int
+	Availability string // AvailabilityInStock or AvailabilityOutOfStock
+}
+
+const (
+	AvailabilityInStock    = "in_stock"
+	AvailabilityOutOfStock = "out_of_stock"
+)
+
+// Names of the filters, used to leave one out when counting facets.
+const (
+	filterCategory     = "category"
+	filterTags         = "tags"
+	filterPrice        = "price"
+	filterAvailability = "availability"
+)
+
+// inStockCondition is true when the product's inventory holds at least one item.
+// Products without an inventory are out of stock.
+const inStockCondition = `EXISTS (SELECT 1 FROM product_inventory i WHERE i.id = p.inventory_id AND i.quantity > 0)`
+
+// productDocument is the text search document of a product, weighting the name
+// above the tags above the description. Accents are removed, so searches match
+// with or without them. It is stored on the product and kept up to date by
+// triggers, so searches can use its index.
+const productDocument = `p.search_document`
+
+// searchCondition matches the products whose document contains every word of
+// the search, or whose name is similar enough to it to allow for typos.
+func searchCondition(search string) string {
+	return `(` + productDocument + ` @@ plainto_tsquery('simple', f_unaccent(` + search + `))
+		OR f_unaccent(` + search + `) <% f_unaccent(p.name))`
+}
+
+// searchRank orders the results of searchCondition, full-text matches first.
+func searchRank(search string) string {
+	return `(ts_rank(` + productDocument + `, plainto_tsquery('simple', f_unaccent(` + search + `)))
+		+ word_similarity(f_unaccent(` + search + `), f_unaccent(p.name)) / 10)`
+}
+
+// queryArgs collects the arguments of a query, handing out their placeholders.
+type queryArgs []any
+
+func (a *queryArgs) add(value any) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// conditions returns the WHERE clause of products which match the filter, except
+// for the filter named by skip, adding the arguments to args.
+func (f ProductFilter) conditions(args *queryArgs, skip string) string {
+	conditions := []string{"p.is_deleted = false"}
+	if f.Category != "" && skip != filterCategory {
+		conditions = append(conditions, fmt.Sprintf(`p.category_id IN (
+		WITH RECURSIVE category_tree AS (
+		    SELECT id FROM product_category
+		    WHERE (slug = %[1]s OR name = %[1]s) AND is_deleted = false
+		    UNION
+		    SELECT c.id FROM product_category c
+		    JOIN category_tree ct ON c.parent_id = ct.id
+		    WHERE c.is_deleted = false
+		)
+		SELECT id FROM category_tree)`, args.add(f.Category)))
+	}
+	if f.Name != "" {
+		conditions = append(conditions, searchCondition(args.add(f.Name)))
+	}
+	if skip != filterPrice {
+		if f.PriceFrom != 0 {
+			conditions = append(conditions, "p.price >= "+args.add(f.PriceFrom))
+		}
+		if f.PriceTo != 0 {
+			conditions = append(conditions, "p.price <= "+args.add(f.PriceTo))
+		}
+	}
+	if len(f.Tags) > 0 && skip != filterTags {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+		SELECT 1 FROM tag_product tp JOIN tag t ON t.id = tp.tag_id
+		WHERE tp.product_id = p.id AND t.is_deleted = false AND t.name = ANY(%s))`, args.add(pq.Array(f.Tags))))
+	}
+	if skip != filterAvailability {
+		switch f.Availability {
+		case AvailabilityInStock:
+			conditions = append(conditions, inStockCondition)
+		case AvailabilityOutOfStock:
+			conditions = append(conditions, "NOT "+inStockCondition)
+		}
+	}
+	return strings.Join(conditions, "\n  AND ")
+}
+
+// productPage is the cached result of GetAll.
+type productPage struct {
+	Products []*Product `json:"products"`
+	Metadata Metadata   `json:"metadata"`
+}
+
+// normalized returns the filter with its tags sorted and deduplicated and the
+// search trimmed and lower case, so equivalent filters share a cache key.
+func (f ProductFilter) normalized() ProductFilter {
+	tags := slices.Clone(f.Tags)
+	slices.Sort(tags)
+	f.Tags = slices.Compact(tags)
+	f.Name = strings.ToLower(strings.TrimSpace(f.Name))
+	return f
+}
+
+// GetAll lists the products matching the filter. Sorting by relevance ranks the
+// results of the name search, and sorts by name when there's no search. Pages
+// but the last have a next cursor and pages but the first a previous cursor,
+// except when sorting by relevance.
+func (m ProductModel) GetAll(filters Filters, productFilter ProductFilter) ([]*Product, Metadata, error) {
+	productFilter = productFilter.normalized()
+	key := cache.Key(filters.Page, filters.PageSize, filters.Sort, filters.Cursor, productFilter)
+	page, err := cache.Fetch(m.Cache, "products", key, func() (productPage, error) {
+		products, metadata, err := m.getAll(filters, productFilter)
+		return productPage{Products: products, Metadata: metadata}, err
+	})
+	return page.Products, page.Metadata, err
+}
+
+func (m ProductModel) getAll(filters Filters, productFilter ProductFilter) ([]*Product, Metadata, error) {
+	args := queryArgs{}
+	where := productFilter.conditions(&args, "")
+	column, direction := "p."+filters.sortColumn(), filters.sortDirection()
+	orderBy := column + " " + direction + ", p.id " + direction
+	if filters.sortColumn() == "relevance" {
+		orderBy = "p.name ASC, p.id ASC"
+		if productFilter.Name != "" {
+			orderBy = searchRank(args.add(productFilter.Name)) + " DESC, p.id ASC"
+		}
+	}
+
+	// With a cursor, the page starts after the cursor's record in the sort order,
+	// or for a previous cursor ends before it, which is found by reading backwards.
+	// One more record than needed tells whether there's a page beyond this one.
+	var cursor *pageCursor
+	count, limit := "count(*) OVER()", args.add(filters.limit())+" OFFSET "+args.add(filters.offset())
+	if filters.Cursor != "" {
+		var err error
+		cursor, err = decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		value, err := cursor.cursorValue()
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comparison := ">"
+		if (direction == "DESC") != cursor.Before {
+			comparison = "<"
+		}
+		if cursor.Before {
+			reverse := "DESC"
+			if direction == "DESC" {
+				reverse = "ASC"
+			}
+			orderBy = column + " " + reverse + ", p.id " + reverse
+		}
+		where += fmt.Sprintf("\n  AND (%s, p.id) %s (%s, %s)", column, comparison, args.add(value), args.add(cursor.ID))
+		count, limit = "0", args.add(filters.limit()+1)
+	}
+
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), 
+SELECT %s, 
        p.id, 
+       p.sku, 
        p.name, 
        p.price, 
        p.image, 
@@ -63,37 +252,21 @@ SELECT count(*) OVER(),
        p.is_deleted, 
        p.created_at, 
        p.modified_at,
-       array_agg(t.name) AS tags
+       array(
+           SELECT t.name FROM tag_product pt
+           JOIN tag t ON pt.tag_id = t.id AND t.is_deleted = false
+           WHERE pt.product_id = p.id
+           ORDER BY t.name
+       ) AS tags
 FROM product p
-LEFT JOIN product_category c ON p.category_id = c.id
-LEFT JOIN tag_product pt ON p.id = pt.product_id
-LEFT JOIN tag t ON pt.tag_id = t.id
-WHERE (c.name = $1 OR $1 = '')
-  AND (to_tsvector('simple', p.name) @@ plainto_tsquery('simple', $2) OR $2 = '')
-  AND (p.price >= $3 OR $3 = 0)
-  AND (p.price <= $4 OR $4 = 0)
-  AND ($5 = '{}'::text[] OR t.name = ANY($5))
-  AND p.is_deleted = false
-GROUP BY p.id, p.name, p.price, p.image, p.image_list, p.description, 
-         p.category_id, p.inventory_id, p.discount_id, 
-         p.is_deleted, p.created_at, p.modified_at
-ORDER BY %s %s, p.id ASC
-LIMIT $6 OFFSET $7
-`, filters.sortColumn(), filters.sortDirection())
+WHERE %s
+ORDER BY %s
+LIMIT %s
+`, count, where, orderBy, limit)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{
-		category,         // Category name
-		name,             // Full-text search input
-		priceFrom,        // Minimum price
-		priceTo,          // Maximum price
-		pq.Array(tags),   // Array of tag names
-		filters.limit(),  // Limit for pagination
-		filters.offset(), // Offset for pagination
-	}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -104,13 +277,15 @@ LIMIT $6 OFFSET $7
 	products := []*Product{}
 	for rows.Next() {
 		var product Product
+		var imageList []string
 		err := rows.Scan(
 			&totalRecords,
 			&product.Id,
+			&product.Sku,
 			&product.Name,
 			&product.Price,
 			&product.Image,
-			&product.ImageList,
+			pq.Array(&imageList),
 			&product.Description,
 			&product.CategoryId,
 			&product.InventoryId,
@@ -123,45 +298,127 @@ LIMIT $6 OFFSET $7
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		product.setImageList(imageList)
 		products = append(products, &product)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if cursor == nil {
+		metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		if filters.sortColumn() != "relevance" && len(products) > 0 {
+			if filters.Page < metadata.LastPage {
+				metadata.NextCursor = productCursor(filters.Sort, products[len(products)-1], false)
+			}
+			if filters.Page > 1 {
+				metadata.PrevCursor = productCursor(filters.Sort, products[0], true)
+			}
+		}
+		return products, metadata, nil
+	}
+
+	more := len(products) > filters.PageSize
+	if more {
+		products = products[:filters.PageSize]
+	}
+	if cursor.Before {
+		slices.Reverse(products)
+	}
+	metadata := Metadata{PageSize: filters.PageSize}
+	if len(products) > 0 {
+		// Coming from a page means there's a page on that side.
+		if more || cursor.Before {
+			metadata.NextCursor = productCursor(filters.Sort, products[len(products)-1], false)
+		}
+		if more || !cursor.Before {
+			metadata.PrevCursor = productCursor(filters.Sort, products[0], true)
+		}
+	}
 	return products, metadata, nil
 }
 
+// productCursor is the cursor of the page after the product, or with before the
+// page before it.
+func productCursor(sort string, p *Product, before bool) string {
+	var value any
+	switch strings.TrimPrefix(sort, "-") {
+	case "name":
+		value = p.Name
+	case "price":
+		value = p.Price
+	case "modified_at":
+		value = p.ModifiedAt
+	}
+	return encodeCursor(sort, value, p.Id, before)
+}
+
 func (m ProductModel) Get(id uuid.UUID) (*Product, error) {
-	query := `SELECT id, name, price, image, image_list, description, category_id, inventory_id, discount_id, is_deleted, created_at, modified_at
+	return cache.Fetch(m.Cache, "product", id.String(), func() (*Product, error) {
+		return m.get(id)
+	})
+}
+
+func (m ProductModel) get(id uuid.UUID) (*Product, error) {
+	query := `SELECT id, sku, name, price, image, image_list, description, category_id, inventory_id, discount_id, is_deleted, created_at, modified_at
 	FROM product
 	WHERE id = $1`
 	var product Product
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&product.Id, &product.Name, &product.Price, &product.Image, &product.ImageList, &product.Description, &product.CategoryId, &product.InventoryId, &product.DiscountId, &product.IsDeleted, &product.CreatedAt, &product.ModifiedAt)
+	var imageList []string
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&product.Id, &product.Sku, &product.Name, &product.Price, &product.Image, pq.Array(&imageList), &product.Description, &product.CategoryId, &product.InventoryId, &product.DiscountId, &product.IsDeleted, &product.CreatedAt, &product.ModifiedAt)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	product.setImageList(imageList)
+	return &product, nil
+}
+
+// GetByName returns the product which isn't deleted with exactly this name.
+func (m ProductModel) GetByName(name string) (*Product, error) {
+	query := `SELECT id, sku, name, price, image, image_list, description, category_id, inventory_id, discount_id, is_deleted, created_at, modified_at
+	FROM product
+	WHERE name = $1 AND is_deleted = false
+	LIMIT 1`
+	var product Product
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var imageList []string
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&product.Id, &product.Sku, &product.Name, &product.Price, &product.Image, pq.Array(&imageList), &product.Description, &product.CategoryId, &product.InventoryId, &product.DiscountId, &product.IsDeleted, &product.CreatedAt, &product.ModifiedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
+	product.setImageList(imageList)
 	return &product, nil
 }
 func (m ProductModel) Update(product *Product) error {
 	query := `UPDATE product
-	SET name = $1, price = $2, image = $3, image_list = $4, description = $5, category_id = $6, inventory_id = $7, discount_id = $8, modified_at = $9
+	SET name = $1, price = $2, image = $3, image_list = $4, description = $5, category_id = $6, inventory_id = $7, discount_id = $8, modified_at = $9, sku = $11
 	WHERE id = $10`
-	args := []interface{}{product.Name, product.Price, product.Image, pq.Array(product.ImageList), product.Description, product.CategoryId, product.InventoryId, product.DiscountId, time.Now(), product.Id}
+	args := []interface{}{product.Name, product.Price, product.Image, pq.Array(product.ImageList), product.Description, product.CategoryId, product.InventoryId, product.DiscountId, time.Now(), product.Id, product.Sku}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
+	m.Cache.Invalidate()
 	return nil
 }
 func (m ProductModel) Delete(id uuid.UUID) error {
 	query := `UPDATE product
-	SET is_deleted = true
+	SET is_deleted = true, modified_at = now()
 	WHERE id = $1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -169,5 +426,141 @@ func (m ProductModel) Delete(id uuid.UUID) error {
 	if err != nil {
 		return err
 	}
+	m.Cache.Invalidate()
+	return nil
+}
+
+// ProductSuggestion is a product suggested while typing a search.
+type ProductSuggestion struct {
+	Id    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Price int       `json:"price"`
+	Image *string   `json:"image"`
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest returns up to limit products whose name contains the search or is
+// similar to it, ignoring accents. Names starting with the search come first.
+func (m ProductModel) Suggest(search string, limit int) ([]*ProductSuggestion, error) {
+	key := cache.Key(strings.ToLower(search), limit)
+	return cache.Fetch(m.Cache, "suggestions", key, func() ([]*ProductSuggestion, error) {
+		return m.suggest(search, limit)
+	})
+}
+
+func (m ProductModel) suggest(search string, limit int) ([]*ProductSuggestion, error) {
+	query := `
+SELECT p.id, p.name, p.price, p.image
+FROM product p
+WHERE p.is_deleted = false
+  AND (f_unaccent(p.name) ILIKE '%' || f_unaccent($2) || '%' OR f_unaccent($1) <% f_unaccent(p.name))
+ORDER BY f_unaccent(p.name) ILIKE f_unaccent($2) || '%' DESC,
+         word_similarity(f_unaccent($1), f_unaccent(p.name)) DESC,
+         p.name
+LIMIT $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, search, likeEscaper.Replace(search), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suggestions := []*ProductSuggestion{}
+	for rows.Next() {
+		var suggestion ProductSuggestion
+		err = rows.Scan(&suggestion.Id, &suggestion.Name, &suggestion.Price, &suggestion.Image)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	return suggestions, rows.Err()
+}
+
+// ProductImport is a row of a bulk import. The product is matched to an existing one
+// by SKU; Tags replaces its tags, creating the missing ones. Import sets Created.
+type ProductImport struct {
+	Product *Product
+	Tags    []string
+	Created bool
+}
+
+// ExistingSKUs reports which of the SKUs belong to a product, deleted or not.
+func (m ProductModel) ExistingSKUs(skus []string) (map[string]bool, error) {
+	query := `SELECT sku FROM product WHERE sku = ANY($1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(skus))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var sku string
+		err = rows.Scan(&sku)
+		if err != nil {
+			return nil, err
+		}
+		existing[sku] = true
+	}
+	return existing, rows.Err()
+}
+
+// Import creates or updates the products by SKU in one transaction, so either
+// every row is saved or none is. An update restores a deleted product.
+func (m ProductModel) Import(imports []*ProductImport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+		INSERT INTO product (sku, name, price, image, image_list, description, category_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (sku) DO UPDATE
+		SET name = EXCLUDED.name, price = EXCLUDED.price, image = EXCLUDED.image, image_list = EXCLUDED.image_list,
+		    description = EXCLUDED.description, category_id = EXCLUDED.category_id, is_deleted = false, modified_at = now()
+		RETURNING id, created_at, modified_at, xmax = 0`
+	tagIds := map[string]uuid.UUID{}
+	for _, imp := range imports {
+		p := imp.Product
+		args := []interface{}{p.Sku, p.Name, p.Price, p.Image, pq.Array(p.ImageList), p.Description, p.CategoryId}
+		err = tx.QueryRowContext(ctx, upsert, args...).Scan(&p.Id, &p.CreatedAt, &p.ModifiedAt, &imp.Created)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM tag_product WHERE product_id = $1`, p.Id)
+		if err != nil {
+			return err
+		}
+		for _, name := range imp.Tags {
+			id, ok := tagIds[name]
+			if !ok {
+				err = tx.QueryRowContext(ctx, `SELECT id FROM tag WHERE lower(name) = lower($1) AND is_deleted = false`, name).Scan(&id)
+				if errors.Is(err, sql.ErrNoRows) {
+					err = tx.QueryRowContext(ctx, `INSERT INTO tag (name) VALUES ($1) RETURNING id`, name).Scan(&id)
+				}
+				if err != nil {
+					return err
+				}
+				tagIds[name] = id
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO tag_product (tag_id, product_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, p.Id)
+			if err != nil {
+				return err
+			}
+		}
+		p.Tags = imp.Tags
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate()
 	return nil
 }
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+	"youneon-BE/internal/validator"
+)
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+)
+
+// Refund records money given back to a customer for the payment PaymentId. Money
+// collected on delivery is paid back by bank transfer.
+type Refund struct {
+	Id              uuid.UUID  `json:"id"`
+	OrderId         uuid.UUID  `json:"order_id"`
+	PaymentId       *uuid.UUID `json:"payment_id"`
+	ReturnRequestId *uuid.UUID `json:"return_request_id"`
+	Amount          int        `json:"amount"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ModifiedAt      time.Time  `json:"modified_at"`
+}
+
+type RefundModel struct {
+	DB *sql.DB
+}
+
+func ValidateRefund(v *validator.Validator, refund *Refund, maxAmount int) {
+	v.Check(refund.Amount > 0, "refund_amount", "must be greater than zero")
+	v.Check(refund.Amount <= maxAmount, "refund_amount", "must not be more than the refundable amount")
+}
+
+func insertRefund(ctx context.Context, tx *sql.Tx, refund *Refund) error {
+	query := `
+		INSERT INTO refunds (order_id, payment_id, return_request_id, amount, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, modified_at`
+	args := []interface{}{refund.OrderId, refund.PaymentId, refund.ReturnRequestId, refund.Amount, refund.Status}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&refund.Id, &refund.CreatedAt, &refund.ModifiedAt)
+}
+
+func (m RefundModel) Get(id uuid.UUID) (*Refund, error) {
+	query := `
+		SELECT id, order_id, payment_id, return_request_id, amount, status, created_at, modified_at
+		FROM refunds
+		WHERE id = $1`
+	var refund Refund
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&refund.Id,
+		&refund.OrderId,
+		&refund.PaymentId,
+		&refund.ReturnRequestId,
+		&refund.Amount,
+		&refund.Status,
+		&refund.CreatedAt,
+		&refund.ModifiedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &refund, nil
+}
+
+func (m RefundModel) GetAllByOrderID(id uuid.UUID) ([]*Refund, error) {
+	query := `
+		SELECT id, order_id, payment_id, return_request_id, amount, status, created_at, modified_at
+		FROM refunds
+		WHERE order_id = $1
+		ORDER BY created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refunds := []*Refund{}
+	for rows.Next() {
+		var refund Refund
+		err := rows.Scan(&refund.Id, &refund.OrderId, &refund.PaymentId, &refund.ReturnRequestId, &refund.Amount, &refund.Status, &refund.CreatedAt, &refund.ModifiedAt)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, &refund)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// RefundedAmount is the total of all refunds of an order which have not failed.
+func (m RefundModel) RefundedAmount(orderId uuid.UUID) (int, error) {
+	query := `SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE order_id = $1 AND status <> 'failed'`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var amount int
+	err := m.DB.QueryRowContext(ctx, query, orderId).Scan(&amount)
+	return amount, err
+}
+
+func (m RefundModel) UpdateStatus(refund *Refund) error {
+	query := `
+		UPDATE refunds
+		SET status = $1, modified_at = now()
+		WHERE id = $2 AND status = 'pending'
+		RETURNING modified_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, refund.Status, refund.Id).Scan(&refund.ModifiedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"net/url"
+	"time"
+	"youneon-BE/internal/cache"
+	"youneon-BE/internal/validator"
+)
+
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+)
+
+var ReturnReasons = []string{"damaged", "wrong_item", "not_as_described", "changed_mind", "other"}
+
+type ReturnRequest struct {
+	Id          uuid.UUID `json:"id"`
+	OrderId     uuid.UUID `json:"order_id"`
+	OrderItemId uuid.UUID `json:"order_item_id"`
+	UserId      uuid.UUID `json:"user_id"`
+	Quantity    int       `json:"quantity"`
+	Reason      string    `json:"reason"`
+	Description string    `json:"description"`
+	Photos      []string  `json:"photos"`
+	Status      string    `json:"status"`
+	AdminNote   *string   `json:"admin_note"`
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+}
+
+// ReturnRequestModel invalidates the catalogue Cache when an approved return
+// changes the availability of a product.
+type ReturnRequestModel struct {
+	DB    *sql.DB
+	Cache *cache.Cache
+}
+
+func ValidateReturnRequest(v *validator.Validator, ret *ReturnRequest) {
+	v.Check(ret.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(validator.PermittedValue(ret.Reason, ReturnReasons...), "reason", "invalid reason value")
+	v.Check(len(ret.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+	v.Check(len(ret.Photos) <= 5, "photos", "must not contain more than 5 photos")
+	v.Check(validator.Unique(ret.Photos), "photos", "must not contain duplicate values")
+	for _, photo := range ret.Photos {
+		u, err := url.ParseRequestURI(photo)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https"), "photos", "must be valid URLs")
+	}
+}
+
+const returnRequestColumns = `id, order_id, order_item_id, user_id, quantity, reason, description, photos, status, admin_note, created_at, modified_at`
+
+func scanReturnRequest(row interface{ Scan(...any) error }, ret *ReturnRequest) error {
+	return row.Scan(
+		&ret.Id,
+		&ret.OrderId,
+		&ret.OrderItemId,
+		&ret.UserId,
+		&ret.Quantity,
+		&ret.Reason,
+		&ret.Description,
+		pq.Array(&ret.Photos),
+		&ret.Status,
+		&ret.AdminNote,
+		&ret.CreatedAt,
+		&ret.ModifiedAt,
+	)
+}
+
+// Insert opens a return, failing with ErrReturnQuantity when the order item has
+// fewer units left to return than requested. The order item stays locked until
+// the return is inserted, so concurrent requests can't return more than was
+// bought together.
+func (m ReturnRequestModel) Insert(ret *ReturnRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ordered int
+	err = tx.QueryRowContext(ctx, `SELECT quantity FROM order_items WHERE id = $1 FOR UPDATE`, ret.OrderItemId).Scan(&ordered)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	var returned int
+	query := `SELECT COALESCE(SUM(quantity), 0) FROM return_requests WHERE order_item_id = $1 AND status <> 'rejected'`
+	err = tx.QueryRowContext(ctx, query, ret.OrderItemId).Scan(&returned)
+	if err != nil {
+		return err
+	}
+	if ret.Quantity > ordered-returned {
+		return ErrReturnQuantity
+	}
+
+	query = `
+		INSERT INTO return_requests (order_id, order_item_id, user_id, quantity, reason, description, photos, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, modified_at`
+	args := []interface{}{ret.OrderId, ret.OrderItemId, ret.UserId, ret.Quantity, ret.Reason, ret.Description, pq.Array(ret.Photos), ret.Status}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&ret.Id, &ret.CreatedAt, &ret.ModifiedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m ReturnRequestModel) Get(id uuid.UUID) (*ReturnRequest, error) {
+	query := `SELECT ` + returnRequestColumns + ` FROM return_requests WHERE id = $1`
+	var ret ReturnRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := scanReturnRequest(m.DB.QueryRowContext(ctx, query, id), &ret)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &ret, nil
+}
+
+func (m ReturnRequestModel) GetAllByUserID(id uuid.UUID) ([]*ReturnRequest, error) {
+	query := `SELECT ` + returnRequestColumns + ` FROM return_requests WHERE user_id = $1 ORDER BY created_at DESC`
+	return m.list(query, id)
+}
+
+// GetAll lists return requests for the admin queue, optionally filtered by status.
+func (m ReturnRequestModel) GetAll(status string) ([]*ReturnRequest, error) {
+	query := `SELECT ` + returnRequestColumns + ` FROM return_requests WHERE (status = $1 OR $1 = '') ORDER BY created_at`
+	return m.list(query, status)
+}
+
+func (m ReturnRequestModel) list(query string, args ...any) ([]*ReturnRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	returns := []*ReturnRequest{}
+	for rows.Next() {
+		var ret ReturnRequest
+		err := scanReturnRequest(rows, &ret)
+		if err != nil {
+			return nil, err
+		}
+		returns = append(returns, &ret)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return returns, nil
+}
+
+// Approve marks a requested return as approved, puts the returned units back into
+// the product inventory and records the refund (if any) in one transaction. The
+// order stays locked while its refunds are added up, failing with ErrRefundAmount
+// when the refund is more than what is left of the order total, so refunds of
+// returns approved together can't add up to more than was paid.
+func (m ReturnRequestModel) Approve(ret *ReturnRequest, productId uuid.UUID, refund *Refund) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateReturnStatus(ctx, tx, ret, ReturnStatusApproved)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE product_inventory
+		SET quantity = quantity + $1, modified_at = now()
+		WHERE id = (SELECT inventory_id FROM product WHERE id = $2)`
+	_, err = tx.ExecContext(ctx, query, ret.Quantity, productId)
+	if err != nil {
+		return err
+	}
+	if refund != nil {
+		var total, refunded int
+		err = tx.QueryRowContext(ctx, `SELECT total FROM order_details WHERE id = $1 FOR UPDATE`, refund.OrderId).Scan(&total)
+		if err != nil {
+			return err
+		}
+		query = `SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE order_id = $1 AND status <> 'failed'`
+		err = tx.QueryRowContext(ctx, query, refund.OrderId).Scan(&refunded)
+		if err != nil {
+			return err
+		}
+		if refund.Amount > total-refunded {
+			return ErrRefundAmount
+		}
+		err = insertRefund(ctx, tx, refund)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate()
+	return nil
+}
+
+func (m ReturnRequestModel) Reject(ret *ReturnRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = updateReturnStatus(ctx, tx, ret, ReturnStatusRejected)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateReturnStatus only moves requests out of the "requested" state, so two admins
+// deciding on the same request get ErrEditConflict instead of a double restock.
+func updateReturnStatus(ctx context.Context, tx *sql.Tx, ret *ReturnRequest, status string) error {
+	query := `
+		UPDATE return_requests
+		SET status = $1, admin_note = $2, modified_at = now()
+		WHERE id = $3 AND status = 'requested'
+		RETURNING modified_at`
+	err := tx.QueryRowContext(ctx, query, status, ret.AdminNote, ret.Id).Scan(&ret.ModifiedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	ret.Status = status
+	return nil
+}
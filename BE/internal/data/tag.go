@@ -3,8 +3,12 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/google/uuid"
+	"strings"
 	"time"
+	"youneon-BE/internal/cache"
+	"youneon-BE/internal/validator"
 )
 
 type Tag struct {
@@ -15,27 +19,100 @@ type Tag struct {
 	IsDelete   bool      `json:"is_deleted"`
 }
 
+func ValidateTag(v *validator.Validator, tag *Tag) {
+	v.Check(strings.TrimSpace(tag.Name) != "", "name", "must be provided")
+	v.Check(len(tag.Name) <= 100, "name", "must not be more than 100 bytes long")
+	// Tags are separated by commas in the product filter and by "|" in import files.
+	v.Check(!strings.ContainsAny(tag.Name, ",|"), "name", "must not contain commas or |")
+}
+
+// TagModel caches GetAll in Cache, when set, until the catalogue changes.
 type TagModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *cache.Cache
+}
+
+// isDuplicateTagName reports whether err is a violation of the unique index on
+// the names of tags which aren't deleted.
+func isDuplicateTagName(err error) bool {
+	return err.Error() == `pq: duplicate key value violates unique constraint "tag_name_key"`
 }
 
 func (m TagModel) Insert(tag *Tag) error {
 	query := `INSERT INTO tag (name)
-	VALUES ($1)`
+	VALUES ($1)
+	RETURNING id, created_at, modified_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, tag.Name).Scan(&tag.ID, &tag.CreatedAt, &tag.ModifiedAt)
+	if err != nil {
+		switch {
+		case isDuplicateTagName(err):
+			return ErrDuplicateName
+		default:
+			return err
+		}
+	}
+	m.Cache.Invalidate()
+	return nil
+}
+
+// AddToProduct tags the product. Tagging it twice with the same tag is a no-op.
+func (m TagModel) AddToProduct(tagId uuid.UUID, productId uuid.UUID) error {
+	query := `INSERT INTO tag_product (tag_id, product_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, tagId, productId)
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate()
+	return nil
+}
+
+// RemoveFromProduct untags the product, returning ErrRecordNotFound if the
+// product didn't have the tag.
+func (m TagModel) RemoveFromProduct(tagId uuid.UUID, productId uuid.UUID) error {
+	query := `DELETE FROM tag_product
+	WHERE tag_id = $1 AND product_id = $2`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, tag.ID, tag.Name, tag.CreatedAt, tag.ModifiedAt, tag.IsDelete)
+	result, err := m.DB.ExecContext(ctx, query, tagId, productId)
 	if err != nil {
 		return err
 	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	m.Cache.Invalidate()
 	return nil
 }
 func (m TagModel) GetAll() ([]*Tag, error) {
 	query := `SELECT id, name, created_at, modified_at, is_deleted
 	FROM tag WHERE is_deleted = false`
+	return cache.Fetch(m.Cache, "tags", "all", func() ([]*Tag, error) {
+		return m.list(query)
+	})
+}
+
+// GetAllWithDeleted returns every tag, deleted ones included, ordered by name.
+func (m TagModel) GetAllWithDeleted() ([]*Tag, error) {
+	query := `SELECT id, name, created_at, modified_at, is_deleted
+	FROM tag
+	ORDER BY lower(name), is_deleted`
+	return m.list(query)
+}
+
+func (m TagModel) list(query string, args ...any) ([]*Tag, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -58,25 +135,126 @@ func (m TagModel) GetAll() ([]*Tag, error) {
 func (m TagModel) Update(tag *Tag) error {
 	query := `UPDATE tag
 	SET name = $1, modified_at = $2
-	WHERE id = $3`
+	WHERE id = $3
+	RETURNING modified_at`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, tag.Name, time.Now(), tag.ID)
+	err := m.DB.QueryRowContext(ctx, query, tag.Name, time.Now(), tag.ID).Scan(&tag.ModifiedAt)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case isDuplicateTagName(err):
+			return ErrDuplicateName
+		default:
+			return err
+		}
 	}
+	m.Cache.Invalidate()
 	return nil
 }
+
+// Delete soft-deletes the tag. Products keep it, so Restore brings it back, but
+// it is hidden from listings.
 func (m TagModel) Delete(id uuid.UUID) error {
 	query := `UPDATE tag
-	SET is_deleted = true
-	WHERE id = $1`
+	SET is_deleted = true, modified_at = now()
+	WHERE id = $1 AND is_deleted = false`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	m.Cache.Invalidate()
+	return nil
+}
+
+// Restore undoes Delete. It returns ErrDuplicateName if another tag has taken
+// the name in the meantime.
+func (m TagModel) Restore(tag *Tag) error {
+	query := `UPDATE tag
+	SET is_deleted = false, modified_at = now()
+	WHERE id = $1 AND is_deleted = true
+	RETURNING modified_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, tag.ID).Scan(&tag.ModifiedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case isDuplicateTagName(err):
+			return ErrDuplicateName
+		default:
+			return err
+		}
+	}
+	tag.IsDelete = false
+	m.Cache.Invalidate()
+	return nil
+}
+
+// Merge moves the products of the source tag to the target tag and deletes the
+// source tag. Both tags must exist and not be deleted.
+func (m TagModel) Merge(sourceId uuid.UUID, targetId uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM tag
+		WHERE id IN ($1, $2) AND is_deleted = false
+		FOR UPDATE`, sourceId, targetId)
+	if err != nil {
+		return err
+	}
+	live := 0
+	for rows.Next() {
+		live++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if live != 2 {
+		return ErrRecordNotFound
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO tag_product (tag_id, product_id)
+		SELECT $2, product_id FROM tag_product WHERE tag_id = $1
+		ON CONFLICT DO NOTHING`, sourceId, targetId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM tag_product WHERE tag_id = $1`, sourceId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE tag SET is_deleted = true, modified_at = now() WHERE id = $1`, sourceId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE tag SET modified_at = now() WHERE id = $1`, targetId)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate()
 	return nil
 }
 func (m TagModel) Get(id uuid.UUID) (*Tag, error) {
@@ -88,33 +266,19 @@ func (m TagModel) Get(id uuid.UUID) (*Tag, error) {
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.ModifiedAt, &tag.IsDelete)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 	return &tag, nil
 }
 func (m TagModel) GetAllTagByProductID(id uuid.UUID) ([]*Tag, error) {
 	query := `SELECT t.id, t.name, t.created_at, t.modified_at, t.is_deleted
 	FROM tag t
-	JOIN product_tag pt ON t.id = pt.tag_id
+	JOIN tag_product pt ON t.id = pt.tag_id
 	WHERE pt.product_id = $1 AND t.is_deleted = false`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	rows, err := m.DB.QueryContext(ctx, query, id)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	tags := []*Tag{}
-	for rows.Next() {
-		var tag Tag
-		err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.ModifiedAt, &tag.IsDelete)
-		if err != nil {
-			return nil, err
-		}
-		tags = append(tags, &tag)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
-	return tags, nil
+	return m.list(query, id)
 }
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+// TwoFactor holds a user's TOTP secret. The row is created on enrollment and the
+// second factor only becomes required once EnabledAt is set, i.e. after the user
+// proved their authenticator app produces valid codes. LastCounter is the TOTP
+// period of the last accepted code; codes from that period or earlier are refused
+// so a code can't be replayed.
+type TwoFactor struct {
+	UserId      uuid.UUID  `json:"-"`
+	Secret      string     `json:"-"`
+	EnabledAt   *time.Time `json:"enabled_at"`
+	LastCounter int64      `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (tf *TwoFactor) Enabled() bool {
+	return tf != nil && tf.EnabledAt != nil
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// GenerateRecoveryCodes returns a fresh set of one-time recovery codes together
+// with their hashes. Only the hashes are stored; the codes are shown to the user
+// once.
+func GenerateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code the way it is stored, ignoring case,
+// dashes and spaces the user may or may not have typed.
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+func (m TwoFactorModel) Get(userId uuid.UUID) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_counter, created_at
+		FROM user_two_factor
+		WHERE user_id = $1`
+	var tf TwoFactor
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userId).Scan(&tf.UserId, &tf.Secret, &tf.EnabledAt, &tf.LastCounter, &tf.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &tf, nil
+}
+
+// Enroll stores a new, not yet enabled, secret for the user, replacing a previous
+// unfinished enrollment. It returns ErrEditConflict when 2FA is already enabled.
+func (m TwoFactorModel) Enroll(tf *TwoFactor) error {
+	query := `
+		INSERT INTO user_two_factor (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_counter = 0, created_at = NOW()
+		WHERE user_two_factor.enabled_at IS NULL
+		RETURNING last_counter, created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, tf.UserId, tf.Secret).Scan(&tf.LastCounter, &tf.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Enable turns 2FA on after the first valid code and stores the recovery codes,
+// in one transaction.
+func (m TwoFactorModel) Enable(tf *TwoFactor, counter int64, recoveryHashes [][]byte) error {
+	query := `
+		UPDATE user_two_factor
+		SET enabled_at = NOW(), last_counter = $2
+		WHERE user_id = $1 AND enabled_at IS NULL
+		RETURNING enabled_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, query, tf.UserId, counter).Scan(&tf.EnabledAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	tf.LastCounter = counter
+	err = replaceRecoveryCodes(ctx, tx, tf.UserId, recoveryHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseCounter records that the code of the given period was used. It returns
+// ErrEditConflict when that code, or a later one, was already accepted.
+func (m TwoFactorModel) UseCounter(userId uuid.UUID, counter int64) error {
+	query := `
+		UPDATE user_two_factor
+		SET last_counter = $2
+		WHERE user_id = $1 AND last_counter < $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userId, counter)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code as used. It returns ErrRecordNotFound
+// when the code doesn't exist or was used before.
+func (m TwoFactorModel) UseRecoveryCode(userId uuid.UUID, hash []byte) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userId, hash)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m TwoFactorModel) ReplaceRecoveryCodes(userId uuid.UUID, hashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = replaceRecoveryCodes(ctx, tx, userId, hashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecoveryCodesLeft counts the unused recovery codes.
+func (m TwoFactorModel) RecoveryCodesLeft(userId uuid.UUID) (int, error) {
+	query := `SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userId).Scan(&count)
+	return count, err
+}
+
+// Delete turns 2FA off and forgets the secret and recovery codes.
+func (m TwoFactorModel) Delete(userId uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_two_factor WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId uuid.UUID, hashes [][]byte) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userId, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
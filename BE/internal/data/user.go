@@ -2,25 +2,40 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"time"
+	"youneon-BE/internal/data/mailer"
 	"youneon-BE/internal/validator"
 )
 
 var AnonymousUser = &User{}
 
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
+// Languages the shop can talk to customers in, the locales of the email templates.
+var Languages = mailer.Locales
+
 type User struct {
-	ID         uuid.UUID `json:"id"`
-	Email      string    `json:"email"`
-	FirstName  string    `json:"first-name"`
-	LastName   string    `json:"last_name"`
-	Password   password  `json:"-"`
-	Telephone  string    `json:"telephone"`
-	CreatedAt  time.Time `json:"created_at"`
-	ModifiedAt time.Time `json:"modified_at"`
+	ID         uuid.UUID  `json:"id"`
+	Email      string     `json:"email"`
+	FirstName  string     `json:"first-name"`
+	LastName   string     `json:"last_name"`
+	Password   password   `json:"-"`
+	Telephone  string     `json:"telephone"`
+	Role       string     `json:"role"`
+	Language   string     `json:"language"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ModifiedAt time.Time  `json:"modified_at"`
+	DeletedAt  *time.Time `json:"-"`
 }
 type UserModel struct {
 	DB *sql.DB
@@ -30,6 +45,15 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// IsDeleted reports whether the account was closed with Anonymize().
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
 type password struct {
 	plaintext *string //Maximum length of 72 bytes, use pointer to hide password
 	hash      []byte
@@ -73,6 +97,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(len(user.LastName) <= 500, "last_name", "must not be more than 500 bytes long")
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
+	v.Check(validator.PermittedValue(user.Language, Languages...), "language", "must be one of "+strings.Join(Languages, ", "))
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
@@ -82,18 +107,27 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 func (m UserModel) Insert(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertUser(ctx, m.DB, user)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertUser(ctx context.Context, q queryRower, user *User) error {
 	query := `
-		INSERT INTO users (email, first_name, last_name, telephone, password_hash)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`
+		INSERT INTO users (email, first_name, last_name, telephone, password_hash, language)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, role`
 
 	if user.Telephone == "" {
 		user.Telephone = "0"
 	}
-	args := []interface{}{user.Email, user.FirstName, user.LastName, user.Telephone, user.Password.hash}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID)
+	args := []interface{}{user.Email, user.FirstName, user.LastName, user.Telephone, user.Password.hash, user.Language}
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Role)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "user_username_key"`:
@@ -106,13 +140,13 @@ func (m UserModel) Insert(user *User) error {
 }
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, email, first_name, last_name,telephone, password_hash, created_at, modified_at
+		SELECT id, email, first_name, last_name,telephone, password_hash, role, language, created_at, modified_at, deleted_at
 		FROM users
 		WHERE email = $1`
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Telephone, &user.Password.hash, &user.CreatedAt, &user.ModifiedAt)
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Telephone, &user.Password.hash, &user.Role, &user.Language, &user.CreatedAt, &user.ModifiedAt, &user.DeletedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -126,9 +160,9 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET email = $1,first_name = $2, last_name = $3, telephone = $4, password_hash = $5, modified_at = $6
-		WHERE id = $7`
-	args := []interface{}{user.Email, user.FirstName, user.LastName, user.Telephone, user.Password.hash, time.Now(), user.ID}
+		SET email = $1,first_name = $2, last_name = $3, telephone = $4, password_hash = $5, language = $6, modified_at = $7
+		WHERE id = $8`
+	args := []interface{}{user.Email, user.FirstName, user.LastName, user.Telephone, user.Password.hash, user.Language, time.Now(), user.ID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -144,7 +178,7 @@ func (m UserModel) Update(user *User) error {
 }
 func (m UserModel) Get(id uuid.UUID) (*User, error) {
 	query := `
-        SELECT id, email, first_name, last_name,telephone, password_hash, created_at, modified_at
+        SELECT id, email, first_name, last_name,telephone, password_hash, role, language, created_at, modified_at, deleted_at
 		FROM users
 		WHERE id = $1`
 	var user User
@@ -157,8 +191,11 @@ func (m UserModel) Get(id uuid.UUID) (*User, error) {
 		&user.LastName,
 		&user.Telephone,
 		&user.Password.hash,
+		&user.Role,
+		&user.Language,
 		&user.CreatedAt,
 		&user.ModifiedAt,
+		&user.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -170,3 +207,83 @@ func (m UserModel) Get(id uuid.UUID) (*User, error) {
 	}
 	return &user, nil
 }
+
+// Anonymize closes the account. The personal data in users and user_address is
+// overwritten, while the rows themselves stay so the orders, payments and refunds
+// kept for accounting still point at them. Carts, second factors, linked identities,
+// API keys, idempotency keys, the sign-in history and the emails still waiting to
+// be sent are deleted; the sent emails lose their recipient and data, and the
+// return requests their description and photos. Returns ErrEditConflict when the
+// account is already closed.
+func (m UserModel) Anonymize(user *User) error {
+	// Nobody knows this password, so the account can't be signed in to any more.
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	err = user.Password.Set(hex.EncodeToString(b))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+		UPDATE users
+		SET email = $1, first_name = 'Deleted', last_name = 'User', telephone = '0', password_hash = $2, modified_at = now(), deleted_at = now()
+		WHERE id = $3 AND deleted_at IS NULL
+		RETURNING email, first_name, last_name, telephone, modified_at, deleted_at`
+	email := "deleted-" + user.ID.String() + "@deleted.invalid"
+	oldEmail := user.Email
+	err = tx.QueryRowContext(ctx, query, email, user.Password.hash, user.ID).Scan(&user.Email, &user.FirstName, &user.LastName, &user.Telephone, &user.ModifiedAt, &user.DeletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_address
+		SET city = '', district = '', ward = '', detail = '', telephone = '', receiver = '', description = ''
+		WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`DELETE FROM cart_item WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_two_factor WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		`DELETE FROM idempotency_keys WHERE user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_events WHERE user_id = $1 OR email = lower($2)`, user.ID, oldEmail)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM email_outbox WHERE lower(recipient) = lower($1) AND status = 'pending'`, oldEmail)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE email_outbox SET recipient = $1, data = '{}' WHERE lower(recipient) = lower($2)`, email, oldEmail)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE return_requests SET description = '', photos = '{}' WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,279 @@
+// Package keyring signs and verifies our JWTs with a rotating set of keys.
+//
+// Keys live in a directory next to a keys.json manifest:
+//
+//	{"keys": [
+//		{"kid": "2026-10", "file": "2026-10.pem", "active_from": "2026-10-01T00:00:00Z"},
+//		{"kid": "2026-11", "file": "2026-11.pem", "active_from": "2026-11-01T00:00:00Z"}
+//	]}
+//
+// Each file holds a PKCS#8 (or PKCS#1 RSA) private key: RSA keys sign with RS256,
+// Ed25519 keys with EdDSA and P-256 keys with ES256. The newest key whose
+// active_from has passed signs new tokens, so rotation is scheduled by adding the
+// next key ahead of time. A key is published in the JWKS, and accepted, Overlap
+// before it becomes active, so verifiers have fetched it by the time it is used,
+// and until Overlap after the next key took over, so the tokens it signed stay
+// valid until they expire.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/pascaldekloe/jwt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNoSigningKey = errors.New("keyring: no signing key is active")
+
+type Key struct {
+	ID         string
+	Alg        string
+	ActiveFrom time.Time
+	private    crypto.PrivateKey
+	public     crypto.PublicKey
+}
+
+// Keyring holds the keys. Secret, when set, signs tokens while no key is active and
+// keeps verifying HS256 tokens until the first key has been active for Overlap,
+// which lets a deployment move from the shared secret to asymmetric keys without
+// logging everybody out. After that the secret is retired like a superseded key.
+type Keyring struct {
+	Overlap time.Duration
+
+	mu     sync.RWMutex
+	keys   []*Key
+	secret []byte
+}
+
+func New(keys []*Key, secret []byte, overlap time.Duration) *Keyring {
+	k := &Keyring{Overlap: overlap, secret: secret}
+	k.Replace(keys)
+	return k
+}
+
+// Replace swaps the keys, after the manifest was reloaded.
+func (k *Keyring) Replace(keys []*Key) {
+	sorted := append([]*Key(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ActiveFrom.Before(sorted[j].ActiveFrom) })
+	k.mu.Lock()
+	k.keys = sorted
+	k.mu.Unlock()
+}
+
+// current returns the key which signs at t, nil when none is active yet.
+func (k *Keyring) current(t time.Time) *Key {
+	var current *Key
+	for _, key := range k.keys {
+		if !key.ActiveFrom.After(t) {
+			current = key
+		}
+	}
+	return current
+}
+
+// secretAccepted tells whether HS256 tokens signed with the secret are accepted
+// at t: until Overlap after the first key became active, when the tokens the
+// secret signed have expired.
+func (k *Keyring) secretAccepted(t time.Time) bool {
+	return len(k.secret) != 0 && (len(k.keys) == 0 || k.keys[0].ActiveFrom.Add(k.Overlap).After(t))
+}
+
+// published returns the keys which are accepted and listed in the JWKS at t.
+func (k *Keyring) published(t time.Time) []*Key {
+	var keys []*Key
+	for i, key := range k.keys {
+		if key.ActiveFrom.Add(-k.Overlap).After(t) {
+			continue
+		}
+		if i+1 < len(k.keys) && !k.keys[i+1].ActiveFrom.Add(k.Overlap).After(t) {
+			continue // superseded long enough ago for its tokens to have expired
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Sign signs the claims with the current key and sets their kid.
+func (k *Keyring) Sign(c *jwt.Claims) ([]byte, error) {
+	k.mu.RLock()
+	key := k.current(time.Now())
+	secret := k.secret
+	k.mu.RUnlock()
+
+	if key == nil {
+		if len(secret) == 0 {
+			return nil, ErrNoSigningKey
+		}
+		c.KeyID = ""
+		return c.HMACSign(jwt.HS256, secret)
+	}
+	c.KeyID = key.ID
+	switch private := key.private.(type) {
+	case *rsa.PrivateKey:
+		return c.RSASign(jwt.RS256, private)
+	case ed25519.PrivateKey:
+		return c.EdDSASign(private)
+	case *ecdsa.PrivateKey:
+		return c.ECDSASign(jwt.ES256, private)
+	}
+	return nil, fmt.Errorf("keyring: unsupported key type %T", key.private)
+}
+
+// Check verifies the token's signature against the published keys. Use
+// Claims.Valid to complete the verification.
+func (k *Keyring) Check(token []byte) (*jwt.Claims, error) {
+	now := time.Now()
+	k.mu.RLock()
+	keys := k.published(now)
+	secret := k.secret
+	secretAccepted := k.secretAccepted(now)
+	k.mu.RUnlock()
+
+	var register jwt.KeyRegister
+	if secretAccepted {
+		register.Secrets = [][]byte{secret}
+		register.SecretIDs = []string{""}
+	}
+	for _, key := range keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			register.RSAs = append(register.RSAs, public)
+			register.RSAIDs = append(register.RSAIDs, key.ID)
+		case ed25519.PublicKey:
+			register.EdDSAs = append(register.EdDSAs, public)
+			register.EdDSAIDs = append(register.EdDSAIDs, key.ID)
+		case *ecdsa.PublicKey:
+			register.ECDSAs = append(register.ECDSAs, public)
+			register.ECDSAIDs = append(register.ECDSAIDs, key.ID)
+		}
+	}
+	return register.Check(token)
+}
+
+// JWK is a public key in the JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public keys other services verify our tokens with.
+func (k *Keyring) JWKS() []JWK {
+	k.mu.RLock()
+	keys := k.published(time.Now())
+	k.mu.RUnlock()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := []JWK{}
+	for _, key := range keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Alg}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(public.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(public)
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = b64(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = b64(public.Y.FillBytes(make([]byte, size)))
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// Load reads the keys listed in dir/keys.json.
+func Load(dir string) ([]*Key, error) {
+	manifest, err := os.ReadFile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		return nil, err
+	}
+	var input struct {
+		Keys []struct {
+			Kid        string    `json:"kid"`
+			File       string    `json:"file"`
+			ActiveFrom time.Time `json:"active_from"`
+		} `json:"keys"`
+	}
+	err = json.Unmarshal(manifest, &input)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: keys.json: %w", err)
+	}
+
+	var keys []*Key
+	for _, entry := range input.Keys {
+		if entry.Kid == "" {
+			return nil, fmt.Errorf("keyring: key %q has no kid", entry.File)
+		}
+		text, err := os.ReadFile(filepath.Join(dir, entry.File))
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: %s: %w", entry.File, err)
+		}
+		key.ID = entry.Kid
+		key.ActiveFrom = entry.ActiveFrom
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseKey(text []byte) (*Key, error) {
+	block, _ := pem.Decode(text)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	var private crypto.PrivateKey
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &Key{Alg: jwt.RS256, private: private, public: &private.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &Key{Alg: jwt.EdDSA, private: private, public: private.Public()}, nil
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		return &Key{Alg: jwt.ES256, private: private, public: &private.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", private)
+}
@@ -0,0 +1,295 @@
+// Package oidc implements the client side of the OpenID Connect authorization code
+// flow with PKCE, against any provider whose endpoints are either configured or
+// discoverable from its issuer.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pascaldekloe/jwt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownProvider = errors.New("oidc: unknown provider")
+	ErrInvalidIDToken  = errors.New("oidc: invalid ID token")
+)
+
+// Provider is an OpenID Connect provider. AuthURL, TokenURL and JWKSURL may be left
+// empty, they are then read from the issuer's discovery document on first use.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AuthURL      string
+	TokenURL     string
+	JWKSURL      string
+	Scopes       []string
+	Client       *http.Client
+
+	mu          sync.Mutex
+	discovered  bool
+	keys        *jwt.KeyRegister
+	keysFetched time.Time
+}
+
+// IDToken holds the claims of a verified ID token we care about.
+type IDToken struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// discover fills in the endpoints which weren't configured.
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered || (p.AuthURL != "" && p.TokenURL != "" && p.JWKSURL != "") {
+		return nil
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return err
+	}
+	if doc.Issuer != p.Issuer {
+		return fmt.Errorf("oidc: discovery document of %q is for issuer %q", p.Issuer, doc.Issuer)
+	}
+	if p.AuthURL == "" {
+		p.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = doc.TokenEndpoint
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = doc.JWKSURI
+	}
+	p.discovered = true
+	return nil
+}
+
+// AuthCodeURL returns the URL of the provider's sign-in page the browser is sent to.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(codeVerifier))
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the verified ID
+// token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*IDToken, error) {
+	err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %d: %s", res.StatusCode, body)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify checks the ID token's signature against the provider's JWKS, its issuer,
+// audience, validity window and nonce.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*IDToken, error) {
+	err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.jwks(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := keys.Check([]byte(rawIDToken))
+	if errors.Is(err, jwt.ErrSigMiss) {
+		// The provider may have rotated its keys since we fetched them.
+		keys, err = p.jwks(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		claims, err = keys.Check([]byte(rawIDToken))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != p.Issuer || !claims.AcceptAudience(p.ClientID) {
+		return nil, fmt.Errorf("%w: wrong issuer or audience", ErrInvalidIDToken)
+	}
+	if claims.Expires == nil || claims.AcceptTemporal(time.Now(), time.Minute) != nil {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if got, _ := claims.String("nonce"); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	token := &IDToken{Subject: claims.Subject}
+	token.Email, _ = claims.String("email")
+	token.GivenName, _ = claims.String("given_name")
+	token.FamilyName, _ = claims.String("family_name")
+	// Some providers send email_verified as a string.
+	switch verified := claims.Set["email_verified"].(type) {
+	case bool:
+		token.EmailVerified = verified
+	case string:
+		token.EmailVerified = verified == "true"
+	}
+	if token.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return token, nil
+}
+
+// jwks returns the provider's signing keys, fetching them when they aren't known
+// yet or, on refresh, when the last fetch is more than a minute old.
+func (p *Provider) jwks(ctx context.Context, refresh bool) (*jwt.KeyRegister, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil && (!refresh || time.Since(p.keysFetched) < time.Minute) {
+		return p.keys, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: JWKS endpoint returned %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	keys := new(jwt.KeyRegister)
+	n, err := keys.LoadJWK(body)
+	if n == 0 {
+		return nil, fmt.Errorf("oidc: no usable key in JWKS: %v", err)
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
+
+type Registry map[string]*Provider
+
+func (reg Registry) Register(provider *Provider) {
+	reg[provider.Name] = provider
+}
+
+func (reg Registry) Get(name string) (*Provider, error) {
+	provider, ok := reg[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// RandomString returns a URL safe random string, used for state, nonce and the
+// PKCE code verifier.
+func RandomString() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CodeChallenge derives the S256 PKCE challenge from the verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
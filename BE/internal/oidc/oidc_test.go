@@ -0,0 +1,272 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/pascaldekloe/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "client"
+	testNonce    = "nonce"
+)
+
+// fakeProvider is a local OpenID Connect provider serving a discovery document,
+// a JWKS with one Ed25519 key and a token endpoint returning IDToken.
+type fakeProvider struct {
+	*httptest.Server
+	key ed25519.PrivateKey
+	kid string
+	// IDToken is what the token endpoint returns.
+	IDToken []byte
+	// verifier is the PKCE code verifier the token endpoint received.
+	verifier atomic.Value
+
+	discoveryHits atomic.Int32
+	jwksHits      atomic.Int32
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	f := &fakeProvider{}
+	f.rotateKey(t, "key-1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		f.discoveryHits.Add(1)
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.jwksHits.Add(1)
+		public := f.key.Public().(ed25519.PublicKey)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": f.kid,
+			"use": "sig",
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		f.verifier.Store(r.PostFormValue("code_verifier"))
+		json.NewEncoder(w).Encode(map[string]string{"id_token": string(f.IDToken)})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeProvider) rotateKey(t *testing.T, kid string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.key, f.kid = key, kid
+}
+
+func (f *fakeProvider) provider() *Provider {
+	return &Provider{Name: "fake", Issuer: f.URL, ClientID: testClientID, RedirectURL: "http://localhost/callback", Scopes: []string{"openid", "email"}}
+}
+
+// claims returns valid claims of an ID token for the nonce testNonce.
+func (f *fakeProvider) claims() *jwt.Claims {
+	now := time.Now()
+	return &jwt.Claims{
+		Registered: jwt.Registered{
+			Issuer:    f.URL,
+			Subject:   "subject",
+			Audiences: []string{testClientID},
+			Issued:    jwt.NewNumericTime(now),
+			Expires:   jwt.NewNumericTime(now.Add(time.Hour)),
+		},
+		Set: map[string]any{
+			"nonce":          testNonce,
+			"email":          "user@example.com",
+			"email_verified": true,
+			"given_name":     "Minh",
+		},
+	}
+}
+
+func (f *fakeProvider) sign(t *testing.T, c *jwt.Claims, key ed25519.PrivateKey) string {
+	token, err := c.EdDSASign(key, json.RawMessage(`{"kid":"`+f.kid+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
+
+func TestDiscovery(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	for range 2 {
+		authURL, err := p.AuthCodeURL(context.Background(), "state", testNonce, "verifier")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(authURL, f.URL+"/authorize?") {
+			t.Errorf("auth URL %q doesn't use the discovered endpoint", authURL)
+		}
+		q := u.Query()
+		if q.Get("state") != "state" || q.Get("nonce") != testNonce || q.Get("client_id") != testClientID {
+			t.Errorf("auth URL %q is missing state, nonce or client_id", authURL)
+		}
+		if q.Get("code_challenge") != CodeChallenge("verifier") || q.Get("code_challenge_method") != "S256" {
+			t.Errorf("auth URL %q has the wrong PKCE challenge", authURL)
+		}
+	}
+	if p.TokenURL != f.URL+"/token" || p.JWKSURL != f.URL+"/jwks" {
+		t.Errorf("endpoints not discovered: token %q, jwks %q", p.TokenURL, p.JWKSURL)
+	}
+	if n := f.discoveryHits.Load(); n != 1 {
+		t.Errorf("discovery document fetched %d times, want 1", n)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	p.Issuer = f.URL + "/other"
+	_, err := p.AuthCodeURL(context.Background(), "state", testNonce, "verifier")
+	if err == nil {
+		t.Fatal("a discovery document for another issuer was accepted")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeProvider(t)
+	c := f.claims()
+	c.Set["email_verified"] = "true"
+	f.IDToken = []byte(f.sign(t, c, f.key))
+
+	token, err := f.provider().Exchange(context.Background(), "code", "verifier", testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := IDToken{Subject: "subject", Email: "user@example.com", EmailVerified: true, GivenName: "Minh"}
+	if *token != want {
+		t.Errorf("got %+v, want %+v", *token, want)
+	}
+	if v, _ := f.verifier.Load().(string); v != "verifier" {
+		t.Errorf("token endpoint got code_verifier %q, want %q", v, "verifier")
+	}
+}
+
+func TestExchangeRejectedCode(t *testing.T) {
+	f := newFakeProvider(t)
+	_, err := f.provider().Exchange(context.Background(), "wrong", "verifier", testNonce)
+	if err == nil {
+		t.Fatal("a rejected code returned a token")
+	}
+}
+
+func TestVerifyJWKSLookup(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+	for range 2 {
+		_, err := p.Verify(ctx, f.sign(t, f.claims(), f.key), testNonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.jwksHits.Load(); n != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", n)
+	}
+
+	// A token signed with a key we haven't seen makes us fetch the JWKS again,
+	// at most once a minute.
+	f.rotateKey(t, "key-2")
+	p.keysFetched = time.Now().Add(-2 * time.Minute)
+	_, err := p.Verify(ctx, f.sign(t, f.claims(), f.key), testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.jwksHits.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times after the key rotation, want 2", n)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	f := newFakeProvider(t)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token func() string
+		nonce string
+	}{
+		{"bad signature", func() string { return f.sign(t, f.claims(), otherKey) }, testNonce},
+		{"tampered payload", func() string {
+			parts := strings.Split(f.sign(t, f.claims(), f.key), ".")
+			c := f.claims()
+			c.Subject = "admin"
+			forged := strings.Split(f.sign(t, c, otherKey), ".")
+			return parts[0] + "." + forged[1] + "." + parts[2]
+		}, testNonce},
+		{"nonce mismatch", func() string { return f.sign(t, f.claims(), f.key) }, "other nonce"},
+		{"missing nonce", func() string {
+			c := f.claims()
+			delete(c.Set, "nonce")
+			return f.sign(t, c, f.key)
+		}, testNonce},
+		{"wrong audience", func() string {
+			c := f.claims()
+			c.Audiences = []string{"another client"}
+			return f.sign(t, c, f.key)
+		}, testNonce},
+		{"wrong issuer", func() string {
+			c := f.claims()
+			c.Issuer = "https://evil.example.com"
+			return f.sign(t, c, f.key)
+		}, testNonce},
+		{"expired", func() string {
+			c := f.claims()
+			c.Issued = jwt.NewNumericTime(time.Now().Add(-2 * time.Hour))
+			c.Expires = jwt.NewNumericTime(time.Now().Add(-time.Hour))
+			return f.sign(t, c, f.key)
+		}, testNonce},
+		{"no expiry", func() string {
+			c := f.claims()
+			c.Expires = nil
+			return f.sign(t, c, f.key)
+		}, testNonce},
+		{"no subject", func() string {
+			c := f.claims()
+			c.Subject = ""
+			return f.sign(t, c, f.key)
+		}, testNonce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.provider().Verify(context.Background(), tt.token(), tt.nonce)
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("err = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+)
+
+// CashOnDelivery confirms the order straight away; the payment is collected by the
+// courier so there is no redirect and no callback.
+type CashOnDelivery struct{}
+
+func (p CashOnDelivery) Name() string {
+	return "cod"
+}
+
+func (p CashOnDelivery) CreatePayment(ctx context.Context, req Request) (*Checkout, error) {
+	return &Checkout{}, nil
+}
+
+func (p CashOnDelivery) ParseCallback(r *http.Request) (*Callback, error) {
+	return nil, ErrCallbackNotSupported
+}
+
+func (p CashOnDelivery) IPNResponse(err error) (int, map[string]string) {
+	return http.StatusNotFound, nil
+}
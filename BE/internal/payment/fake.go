@@ -0,0 +1,71 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Fake is a local stand-in for a redirect gateway so the whole checkout flow can be
+// exercised offline. Its "payment page" is the return URL itself: CreatePayment
+// hands back a signed callback with the configured outcome, and CallbackQuery can
+// be used to hand-craft IPN requests.
+type Fake struct {
+	Secret  string
+	Succeed bool
+}
+
+func (p Fake) Name() string {
+	return "fake"
+}
+
+func (p Fake) CreatePayment(ctx context.Context, req Request) (*Checkout, error) {
+	query := p.CallbackQuery(req.TxnRef, req.Amount, p.Succeed)
+	return &Checkout{RedirectURL: req.ReturnURL + "?" + query.Encode()}, nil
+}
+
+// CallbackQuery returns signed callback parameters for the given attempt.
+func (p Fake) CallbackQuery(txnRef string, amount int, success bool) url.Values {
+	params := url.Values{}
+	params.Set("txn_ref", txnRef)
+	params.Set("amount", strconv.Itoa(amount))
+	params.Set("transaction_id", "fake-"+txnRef)
+	params.Set("result", "failed")
+	if success {
+		params.Set("result", "success")
+	}
+	params.Set("signature", sign(sha256.New, p.Secret, params.Encode()))
+	return params
+}
+
+func (p Fake) ParseCallback(r *http.Request) (*Callback, error) {
+	params := r.URL.Query()
+	signature := params.Get("signature")
+	params.Del("signature")
+	if !validSignature(sha256.New, p.Secret, params.Encode(), signature) {
+		return nil, ErrInvalidSignature
+	}
+	amount, err := strconv.Atoi(params.Get("amount"))
+	if err != nil {
+		return nil, errors.New("invalid amount")
+	}
+	return &Callback{
+		TxnRef:        params.Get("txn_ref"),
+		ProviderTxnID: params.Get("transaction_id"),
+		Amount:        amount,
+		Success:       params.Get("result") == "success",
+		Message:       "fake " + params.Get("result"),
+	}, nil
+}
+
+func (p Fake) IPNResponse(err error) (int, map[string]string) {
+	switch {
+	case err == nil, errors.Is(err, ErrAlreadyProcessed):
+		return http.StatusOK, map[string]string{"message": "ok"}
+	default:
+		return http.StatusBadRequest, map[string]string{"message": err.Error()}
+	}
+}
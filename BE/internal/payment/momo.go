@@ -0,0 +1,157 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MoMo implements the MoMo "captureWallet" flow. The payment URL is obtained from
+// MoMo's create API, the customer comes back through a GET redirect and MoMo posts
+// the IPN as JSON. Everything is signed with HMAC-SHA256.
+type MoMo struct {
+	PartnerCode string
+	AccessKey   string
+	SecretKey   string
+	Endpoint    string
+	Client      *http.Client
+}
+
+func (p MoMo) Name() string {
+	return "momo"
+}
+
+func (p MoMo) CreatePayment(ctx context.Context, req Request) (*Checkout, error) {
+	input := map[string]string{
+		"accessKey":   p.AccessKey,
+		"amount":      strconv.Itoa(req.Amount),
+		"extraData":   "",
+		"ipnUrl":      req.IPNURL,
+		"orderId":     req.TxnRef,
+		"orderInfo":   req.OrderInfo,
+		"partnerCode": p.PartnerCode,
+		"redirectUrl": req.ReturnURL,
+		"requestId":   req.TxnRef,
+		"requestType": "captureWallet",
+	}
+	raw := momoRawSignature(input, "accessKey", "amount", "extraData", "ipnUrl", "orderId",
+		"orderInfo", "partnerCode", "redirectUrl", "requestId", "requestType")
+
+	body, err := json.Marshal(map[string]any{
+		"partnerCode": p.PartnerCode,
+		"requestId":   req.TxnRef,
+		"amount":      req.Amount,
+		"orderId":     req.TxnRef,
+		"orderInfo":   req.OrderInfo,
+		"redirectUrl": req.ReturnURL,
+		"ipnUrl":      req.IPNURL,
+		"requestType": "captureWallet",
+		"extraData":   "",
+		"lang":        "vi",
+		"signature":   sign(sha256.New, p.SecretKey, raw),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var output struct {
+		ResultCode int    `json:"resultCode"`
+		Message    string `json:"message"`
+		PayURL     string `json:"payUrl"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&output)
+	if err != nil {
+		return nil, err
+	}
+	if output.ResultCode != 0 {
+		return nil, fmt.Errorf("momo create payment failed: %d %s", output.ResultCode, output.Message)
+	}
+	return &Checkout{RedirectURL: output.PayURL}, nil
+}
+
+func (p MoMo) ParseCallback(r *http.Request) (*Callback, error) {
+	params := r.URL.Query()
+	if r.Method == http.MethodPost {
+		var body map[string]any
+		err := json.NewDecoder(io.LimitReader(r.Body, 1_048_576)).Decode(&body)
+		if err != nil {
+			return nil, err
+		}
+		params = url.Values{}
+		for key, value := range body {
+			switch v := value.(type) {
+			case string:
+				params.Set(key, v)
+			case float64:
+				params.Set(key, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+
+	input := map[string]string{"accessKey": p.AccessKey}
+	for key := range params {
+		input[key] = params.Get(key)
+	}
+	raw := momoRawSignature(input, "accessKey", "amount", "extraData", "message", "orderId", "orderInfo",
+		"orderType", "partnerCode", "payType", "requestId", "responseTime", "resultCode", "transId")
+	if !validSignature(sha256.New, p.SecretKey, raw, params.Get("signature")) {
+		return nil, ErrInvalidSignature
+	}
+	amount, err := strconv.Atoi(params.Get("amount"))
+	if err != nil {
+		return nil, errors.New("invalid momo amount")
+	}
+	return &Callback{
+		TxnRef:        params.Get("orderId"),
+		ProviderTxnID: params.Get("transId"),
+		Amount:        amount,
+		Success:       params.Get("resultCode") == "0",
+		Message:       params.Get("message"),
+	}, nil
+}
+
+func (p MoMo) IPNResponse(err error) (int, map[string]string) {
+	switch {
+	case err == nil, errors.Is(err, ErrAlreadyProcessed):
+		return http.StatusNoContent, nil
+	default:
+		return http.StatusBadRequest, map[string]string{"message": err.Error()}
+	}
+}
+
+// momoRawSignature joins the given keys in the fixed order documented by MoMo.
+func momoRawSignature(input map[string]string, keys ...string) string {
+	var buf bytes.Buffer
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(key + "=" + input[key])
+	}
+	return buf.String()
+}
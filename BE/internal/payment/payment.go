@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrUnknownProvider      = errors.New("unknown payment provider")
+	ErrInvalidSignature     = errors.New("invalid payment signature")
+	ErrCallbackNotSupported = errors.New("payment provider does not send callbacks")
+	// The errors below are not raised by the providers themselves but are passed
+	// back to IPNResponse() by the handlers so each provider can translate them
+	// into the acknowledgement format its gateway expects.
+	ErrPaymentNotFound  = errors.New("payment not found")
+	ErrAmountMismatch   = errors.New("payment amount does not match")
+	ErrAlreadyProcessed = errors.New("payment already processed")
+)
+
+// Request describes a single payment attempt for an order.
+type Request struct {
+	TxnRef    string
+	OrderID   string
+	Amount    int // in VND
+	OrderInfo string
+	ClientIP  string
+	ReturnURL string
+	IPNURL    string
+}
+
+// Checkout is what the client needs to continue the payment. RedirectURL is empty
+// for providers which don't take the customer to an external page (cash on delivery).
+type Checkout struct {
+	RedirectURL string
+}
+
+// Callback is the verified, provider independent content of a return URL or IPN
+// request.
+type Callback struct {
+	TxnRef        string
+	ProviderTxnID string
+	Amount        int
+	Success       bool
+	Message       string
+}
+
+type PaymentProvider interface {
+	Name() string
+	// CreatePayment starts a payment attempt and returns where to send the customer.
+	CreatePayment(ctx context.Context, req Request) (*Checkout, error)
+	// ParseCallback verifies the signature of a return URL or IPN request and
+	// extracts its content. It returns ErrInvalidSignature if the request has been
+	// tampered with.
+	ParseCallback(r *http.Request) (*Callback, error)
+	// IPNResponse returns the status code and body the gateway expects in reply to
+	// an IPN request which was handled with the given error (nil on success).
+	IPNResponse(err error) (int, map[string]string)
+}
+
+type Registry map[string]PaymentProvider
+
+func (reg Registry) Register(provider PaymentProvider) {
+	reg[provider.Name()] = provider
+}
+
+func (reg Registry) Get(name string) (PaymentProvider, error) {
+	provider, ok := reg[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// NewTxnRef generates the merchant reference sent to the gateway. It is unique per
+// attempt so a customer can retry a failed payment for the same order.
+func NewTxnRef() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return time.Now().Format("20060102") + hex.EncodeToString(b)
+}
+
+func sign(h func() hash.Hash, secret, message string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSignature(h func() hash.Hash, secret, message, signature string) bool {
+	expected := sign(h, secret, message)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha512"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VNPay implements the VNPay 2.1.0 redirect flow. Both the return URL and the IPN
+// are GET requests carrying vnp_* parameters signed with HMAC-SHA512.
+type VNPay struct {
+	TmnCode    string
+	HashSecret string
+	PayURL     string
+}
+
+func (p VNPay) Name() string {
+	return "vnpay"
+}
+
+func (p VNPay) CreatePayment(ctx context.Context, req Request) (*Checkout, error) {
+	location, err := time.LoadLocation("Asia/Ho_Chi_Minh")
+	if err != nil {
+		location = time.FixedZone("ICT", 7*60*60)
+	}
+	now := time.Now().In(location)
+
+	params := url.Values{}
+	params.Set("vnp_Version", "2.1.0")
+	params.Set("vnp_Command", "pay")
+	params.Set("vnp_TmnCode", p.TmnCode)
+	// VNPay expects the amount multiplied by 100.
+	params.Set("vnp_Amount", strconv.Itoa(req.Amount*100))
+	params.Set("vnp_CurrCode", "VND")
+	params.Set("vnp_TxnRef", req.TxnRef)
+	params.Set("vnp_OrderInfo", req.OrderInfo)
+	params.Set("vnp_OrderType", "other")
+	params.Set("vnp_Locale", "vn")
+	params.Set("vnp_ReturnUrl", req.ReturnURL)
+	params.Set("vnp_IpAddr", req.ClientIP)
+	params.Set("vnp_CreateDate", now.Format("20060102150405"))
+	params.Set("vnp_ExpireDate", now.Add(15*time.Minute).Format("20060102150405"))
+
+	query := vnpayEncode(params)
+	signature := sign(sha512.New, p.HashSecret, query)
+	return &Checkout{RedirectURL: p.PayURL + "?" + query + "&vnp_SecureHash=" + signature}, nil
+}
+
+func (p VNPay) ParseCallback(r *http.Request) (*Callback, error) {
+	params := r.URL.Query()
+	signature := params.Get("vnp_SecureHash")
+	params.Del("vnp_SecureHash")
+	params.Del("vnp_SecureHashType")
+	if !validSignature(sha512.New, p.HashSecret, vnpayEncode(params), strings.ToLower(signature)) {
+		return nil, ErrInvalidSignature
+	}
+	amount, err := strconv.Atoi(params.Get("vnp_Amount"))
+	if err != nil {
+		return nil, errors.New("invalid vnp_Amount")
+	}
+	return &Callback{
+		TxnRef:        params.Get("vnp_TxnRef"),
+		ProviderTxnID: params.Get("vnp_TransactionNo"),
+		Amount:        amount / 100,
+		Success:       params.Get("vnp_ResponseCode") == "00" && params.Get("vnp_TransactionStatus") == "00",
+		Message:       "vnpay response code " + params.Get("vnp_ResponseCode"),
+	}, nil
+}
+
+func (p VNPay) IPNResponse(err error) (int, map[string]string) {
+	ack := func(code, message string) map[string]string {
+		return map[string]string{"RspCode": code, "Message": message}
+	}
+	// VNPay always wants a 200 and reads the outcome from RspCode.
+	switch {
+	case err == nil:
+		return http.StatusOK, ack("00", "Confirm Success")
+	case errors.Is(err, ErrPaymentNotFound):
+		return http.StatusOK, ack("01", "Order not found")
+	case errors.Is(err, ErrAlreadyProcessed):
+		return http.StatusOK, ack("02", "Order already confirmed")
+	case errors.Is(err, ErrAmountMismatch):
+		return http.StatusOK, ack("04", "Invalid amount")
+	case errors.Is(err, ErrInvalidSignature):
+		return http.StatusOK, ack("97", "Invalid signature")
+	default:
+		return http.StatusOK, ack("99", "Unknown error")
+	}
+}
+
+// vnpayEncode builds the string VNPay signs: parameters sorted by key with
+// url-encoded values, skipping empty ones.
+func vnpayEncode(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if params.Get(key) != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "=" + url.QueryEscape(params.Get(key))
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// MemoryStore keeps buckets in process memory. It is fine for a single instance;
+// use RedisStore when running several.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, p Policy, key string) (Result, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[p.Name+":"+key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Burst), lastSeen: now}
+		s.buckets[p.Name+":"+key] = b
+	}
+	b.tokens = min(float64(p.Burst), b.tokens+now.Sub(b.lastSeen).Seconds()*p.Rate)
+	b.lastSeen = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(p, b.tokens, allowed), nil
+}
+
+// Sweep forgets buckets which haven't been used for the given duration. Call it
+// periodically so the map doesn't grow with every client ever seen.
+func (s *MemoryStore) Sweep(idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, b := range s.buckets {
+		if time.Since(b.lastSeen) > idle {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Policy describes a token bucket: it holds at most Burst tokens and refills at
+// Rate tokens per second. Every request takes one token.
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+// Validate checks the policy refills and holds at least one token, the bucket
+// computations divide by Rate.
+func (p Policy) Validate() error {
+	if !(p.Rate > 0) || math.IsInf(p.Rate, 0) {
+		return fmt.Errorf("rate limit policy %q: rate must be greater than zero", p.Name)
+	}
+	if p.Burst < 1 {
+		return fmt.Errorf("rate limit policy %q: burst must be at least 1", p.Name)
+	}
+	return nil
+}
+
+// PerMinute is a convenience for policies like "5 requests per minute".
+func PerMinute(name string, n int) Policy {
+	return Policy{Name: name, Rate: float64(n) / 60, Burst: n}
+}
+
+// PerHour is a convenience for policies like "3 requests per hour".
+func PerHour(name string, n int) Policy {
+	return Policy{Name: name, Rate: float64(n) / 3600, Burst: n}
+}
+
+// Result is the state of a bucket after a request tried to take a token. It carries
+// everything needed for the RateLimit-* and Retry-After response headers.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until a token is available again, when not allowed
+	Reset      time.Duration // until the bucket is full again
+}
+
+// Store keeps the buckets. Key identifies the bucket within the policy (an IP
+// address, a user id...).
+type Store interface {
+	Take(ctx context.Context, p Policy, key string) (Result, error)
+}
+
+// newResult computes the Result from the number of tokens left in a bucket.
+func newResult(p Policy, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(p.Burst) - tokens) / p.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / p.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
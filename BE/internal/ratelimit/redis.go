@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// The bucket is refilled and decremented atomically on the Redis side. Tokens are
+// returned as a string because Redis truncates Lua numbers to integers.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore shares buckets between all instances of the API.
+type RedisStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (s *RedisStore) Take(ctx context.Context, p Policy, key string) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	args := []any{p.Rate, p.Burst, time.Now().UnixMilli()}
+	values, err := takeScript.Run(ctx, s.Client, []string{s.Prefix + p.Name + ":" + key}, args...).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, _ := values[0].(int64)
+	tokensText, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(p, tokens, allowed == 1), nil
+}
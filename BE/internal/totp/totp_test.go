@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC 6238 appendix B vectors for SHA-1, truncated to the last 6 of their 8
+// digits.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, tt := range rfcVectors {
+		code, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err != ErrInvalidSecret {
+		t.Errorf("err = %v, want ErrInvalidSecret", err)
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := Counter(now)
+	for _, tt := range []struct {
+		offset int64
+		valid  bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	} {
+		code, err := Code(rfcSecret, counter+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched, ok := Validate(rfcSecret, code, now)
+		if ok != tt.valid {
+			t.Errorf("code of period %+d: valid = %t, want %t", tt.offset, ok, tt.valid)
+		}
+		if ok && matched != counter+tt.offset {
+			t.Errorf("code of period %+d matched counter %d, want %d", tt.offset, matched, counter+tt.offset)
+		}
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) = true, want false", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 287082 ", now); !ok {
+		t.Error("Validate should ignore surrounding spaces")
+	}
+}
+
+func TestValidateAfterRejectsUsedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Counter(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, ok := ValidateAfter(rfcSecret, code, now, math.MinInt64)
+	if !ok {
+		t.Fatal("first use of the code was rejected")
+	}
+	if _, ok := ValidateAfter(rfcSecret, code, now, counter); ok {
+		t.Error("the same code was accepted twice")
+	}
+
+	// The code of the previous period is still in the window, but older than the
+	// last code used.
+	previous, err := Code(rfcSecret, counter-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateAfter(rfcSecret, previous, now, counter); ok {
+		t.Error("a code older than the last used one was accepted")
+	}
+	next, err := Code(rfcSecret, counter+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateAfter(rfcSecret, next, now, counter); !ok {
+		t.Error("the code of the next period was rejected")
+	}
+}
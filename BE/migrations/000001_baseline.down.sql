@@ -0,0 +1,29 @@
+-- Drop the tables this migration created, and the columns it added to the tables
+-- it adopted.
+DO $$
+DECLARE
+    t text;
+    c text;
+BEGIN
+    FOREACH c IN ARRAY ARRAY[
+        'users.role', 'users.language', 'users.deleted_at',
+        'order_details.tracking_number', 'order_details.created_at', 'order_items.price'
+    ] LOOP
+        IF EXISTS (SELECT 1 FROM schema_baseline_adopted WHERE name = split_part(c, '.', 1))
+            AND NOT EXISTS (SELECT 1 FROM schema_baseline_adopted WHERE name = c) THEN
+            EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS %I', split_part(c, '.', 1), split_part(c, '.', 2));
+        END IF;
+    END LOOP;
+    FOREACH t IN ARRAY ARRAY[
+        'shortener', 'api_keys', 'user_identities', 'oidc_logins', 'recovery_codes', 'user_two_factor',
+        'login_events', 'idempotency_keys', 'email_outbox', 'refunds', 'return_requests', 'payments',
+        'order_items', 'order_details', 'user_address', 'cart_item', 'tag_product', 'product',
+        'product_inventory', 'tag', 'product_category', 'users'
+    ] LOOP
+        IF NOT EXISTS (SELECT 1 FROM schema_baseline_adopted WHERE name = t) THEN
+            EXECUTE format('DROP TABLE IF EXISTS %I', t);
+        END IF;
+    END LOOP;
+END $$;
+
+DROP TABLE schema_baseline_adopted;
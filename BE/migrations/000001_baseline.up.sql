@@ -0,0 +1,277 @@
+-- Baseline: the schema the queries in internal/data expect. Tables are created with
+-- IF NOT EXISTS so the migration can be recorded on a database that was set up by
+-- hand before migrations existed; the columns added since then are added to such
+-- a database too. The tables and columns which already existed are recorded in
+-- schema_baseline_adopted, so the down migration only drops what this one created.
+
+CREATE TABLE schema_baseline_adopted (
+    name text PRIMARY KEY
+);
+INSERT INTO schema_baseline_adopted (name)
+SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_name IN (
+    'users', 'product_category', 'tag', 'product_inventory', 'product', 'tag_product',
+    'cart_item', 'user_address', 'order_details', 'order_items', 'payments', 'return_requests',
+    'refunds', 'email_outbox', 'idempotency_keys', 'login_events', 'user_two_factor', 'recovery_codes',
+    'oidc_logins', 'user_identities', 'api_keys', 'shortener'
+)
+UNION
+SELECT table_name || '.' || column_name FROM information_schema.columns
+WHERE table_schema = current_schema() AND (table_name, column_name) IN (
+    ('users', 'role'), ('users', 'language'), ('users', 'deleted_at'),
+    ('order_details', 'tracking_number'), ('order_details', 'created_at'), ('order_items', 'price')
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    email text NOT NULL CONSTRAINT user_username_key UNIQUE,
+    first_name text NOT NULL,
+    last_name text NOT NULL,
+    telephone text NOT NULL DEFAULT '0',
+    password_hash bytea NOT NULL,
+    role text NOT NULL DEFAULT 'customer',
+    language text NOT NULL DEFAULT 'vi',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now(),
+    deleted_at timestamptz
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'customer';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT 'vi';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS product_category (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name text NOT NULL,
+    description text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now(),
+    is_deleted boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS tag (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now(),
+    is_deleted boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS product_inventory (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    quantity integer NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- category_id, inventory_id and discount_id hold the zero UUID when a product is
+-- created without them, so they can't be foreign keys.
+CREATE TABLE IF NOT EXISTS product (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name text NOT NULL,
+    price integer NOT NULL CHECK (price >= 0),
+    image text,
+    image_list text[],
+    description text,
+    category_id uuid,
+    inventory_id uuid,
+    discount_id uuid,
+    is_deleted boolean NOT NULL DEFAULT false,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS product_category_id_idx ON product (category_id);
+CREATE INDEX IF NOT EXISTS product_name_fts_idx ON product USING gin (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS tag_product (
+    tag_id uuid NOT NULL REFERENCES tag ON DELETE CASCADE,
+    product_id uuid NOT NULL REFERENCES product ON DELETE CASCADE,
+    PRIMARY KEY (tag_id, product_id)
+);
+CREATE INDEX IF NOT EXISTS tag_product_product_id_idx ON tag_product (product_id);
+
+CREATE TABLE IF NOT EXISTS cart_item (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users ON DELETE CASCADE,
+    product_id uuid NOT NULL REFERENCES product ON DELETE CASCADE,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    UNIQUE (user_id, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_address (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users ON DELETE CASCADE,
+    city text NOT NULL DEFAULT '',
+    district text NOT NULL DEFAULT '',
+    ward text NOT NULL DEFAULT '',
+    detail text NOT NULL DEFAULT '',
+    telephone text NOT NULL DEFAULT '',
+    receiver text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS user_address_user_id_idx ON user_address (user_id);
+
+CREATE TABLE IF NOT EXISTS order_details (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users,
+    total integer NOT NULL,
+    address_detail text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'pending',
+    tracking_number text,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+ALTER TABLE order_details ADD COLUMN IF NOT EXISTS tracking_number text;
+ALTER TABLE order_details ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS order_details_user_id_idx ON order_details (user_id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id uuid NOT NULL REFERENCES order_details ON DELETE CASCADE,
+    product_id uuid NOT NULL REFERENCES product,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    price integer NOT NULL DEFAULT 0
+);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS price integer NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS order_items_order_id_idx ON order_items (order_id);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id uuid NOT NULL REFERENCES order_details,
+    provider text NOT NULL,
+    amount integer NOT NULL,
+    status text NOT NULL,
+    txn_ref text NOT NULL UNIQUE,
+    provider_txn_id text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS payments_order_id_idx ON payments (order_id);
+
+CREATE TABLE IF NOT EXISTS return_requests (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id uuid NOT NULL REFERENCES order_details,
+    order_item_id uuid NOT NULL REFERENCES order_items,
+    user_id uuid NOT NULL REFERENCES users,
+    quantity integer NOT NULL CHECK (quantity > 0),
+    reason text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    photos text[] NOT NULL DEFAULT '{}',
+    status text NOT NULL,
+    admin_note text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS return_requests_user_id_idx ON return_requests (user_id);
+CREATE INDEX IF NOT EXISTS return_requests_order_item_id_idx ON return_requests (order_item_id);
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id uuid NOT NULL REFERENCES order_details,
+    payment_id uuid REFERENCES payments,
+    return_request_id uuid REFERENCES return_requests,
+    amount integer NOT NULL CHECK (amount > 0),
+    status text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    modified_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS refunds_order_id_idx ON refunds (order_id);
+
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    recipient text NOT NULL,
+    locale text NOT NULL,
+    template text NOT NULL,
+    data jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    locked_until timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    sent_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON email_outbox (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key text NOT NULL,
+    user_id uuid NOT NULL REFERENCES users ON DELETE CASCADE,
+    request_method text NOT NULL,
+    request_path text NOT NULL,
+    request_hash text NOT NULL,
+    status_code integer,
+    response_body bytea,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_events (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid REFERENCES users ON DELETE CASCADE,
+    email text NOT NULL,
+    ip text NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    success boolean NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS login_events_email_idx ON login_events (email, created_at);
+CREATE INDEX IF NOT EXISTS login_events_ip_idx ON login_events (ip, created_at);
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS user_two_factor (
+    user_id uuid PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret text NOT NULL,
+    enabled_at timestamptz,
+    last_counter bigint NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users ON DELETE CASCADE,
+    code_hash bytea NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state text PRIMARY KEY,
+    provider text NOT NULL,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users ON DELETE CASCADE,
+    provider text NOT NULL,
+    subject text NOT NULL,
+    email text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash bytea NOT NULL UNIQUE,
+    scopes text[] NOT NULL DEFAULT '{}',
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+CREATE TABLE IF NOT EXISTS shortener (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    long_url text NOT NULL,
+    short_url text NOT NULL UNIQUE
+);
+CREATE INDEX IF NOT EXISTS shortener_long_url_idx ON shortener (long_url);
@@ -0,0 +1,2 @@
+ALTER TABLE product DROP CONSTRAINT IF EXISTS product_sku_key;
+ALTER TABLE product DROP COLUMN IF EXISTS sku;
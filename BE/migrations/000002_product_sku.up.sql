@@ -0,0 +1,4 @@
+-- Products imported from CSV are matched to existing ones by SKU. Products created
+-- otherwise may not have one.
+ALTER TABLE product ADD COLUMN IF NOT EXISTS sku text;
+ALTER TABLE product ADD CONSTRAINT product_sku_key UNIQUE (sku);
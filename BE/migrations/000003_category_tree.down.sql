@@ -0,0 +1,7 @@
+-- The unaccent extension is left installed.
+DROP INDEX IF EXISTS product_category_parent_id_idx;
+ALTER TABLE product_category DROP CONSTRAINT IF EXISTS product_category_slug_key;
+ALTER TABLE product_category DROP COLUMN IF EXISTS image;
+ALTER TABLE product_category DROP COLUMN IF EXISTS sort_order;
+ALTER TABLE product_category DROP COLUMN IF EXISTS slug;
+ALTER TABLE product_category DROP COLUMN IF EXISTS parent_id;
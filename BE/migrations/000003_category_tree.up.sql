@@ -0,0 +1,25 @@
+-- Categories form a tree. The slug identifies a category in URLs and product
+-- filters; existing categories get one made from their name the way Slugify
+-- makes it: accents dropped, đ read as d and everything but [a-z0-9] turned into
+-- dashes.
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+ALTER TABLE product_category ADD COLUMN IF NOT EXISTS parent_id uuid REFERENCES product_category ON DELETE SET NULL;
+ALTER TABLE product_category ADD COLUMN IF NOT EXISTS slug text;
+ALTER TABLE product_category ADD COLUMN IF NOT EXISTS sort_order integer NOT NULL DEFAULT 0;
+ALTER TABLE product_category ADD COLUMN IF NOT EXISTS image text;
+
+UPDATE product_category
+SET slug = trim(BOTH '-' FROM regexp_replace(lower(translate(public.unaccent(name), 'đĐ', 'dd')), '[^a-z0-9]+', '-', 'g'))
+WHERE slug IS NULL;
+
+UPDATE product_category c
+SET slug = concat_ws('-', nullif(c.slug, ''), left(c.id::text, 8))
+WHERE c.slug = '' OR EXISTS (
+    SELECT 1 FROM product_category o
+    WHERE o.slug = c.slug AND (o.created_at, o.id) < (c.created_at, c.id)
+);
+
+ALTER TABLE product_category ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE product_category ADD CONSTRAINT product_category_slug_key UNIQUE (slug);
+CREATE INDEX IF NOT EXISTS product_category_parent_id_idx ON product_category (parent_id);
@@ -0,0 +1,23 @@
+-- Tag names are unique among the tags which aren't deleted, ignoring case. Tags
+-- sharing a name are merged into the oldest one first.
+CREATE TEMPORARY TABLE tag_duplicate ON COMMIT DROP AS
+SELECT id, first_value(id) OVER (PARTITION BY lower(name) ORDER BY created_at, id) AS keep_id
+FROM tag
+WHERE is_deleted = false;
+
+INSERT INTO tag_product (tag_id, product_id)
+SELECT d.keep_id, tp.product_id
+FROM tag_product tp
+JOIN tag_duplicate d ON d.id = tp.tag_id AND d.id <> d.keep_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM tag_product tp
+USING tag_duplicate d
+WHERE d.id = tp.tag_id AND d.id <> d.keep_id;
+
+UPDATE tag t
+SET is_deleted = true, modified_at = now()
+FROM tag_duplicate d
+WHERE d.id = t.id AND d.id <> d.keep_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS tag_name_key ON tag (lower(name)) WHERE is_deleted = false;
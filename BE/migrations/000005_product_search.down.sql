@@ -0,0 +1,5 @@
+-- The unaccent and pg_trgm extensions are left installed.
+DROP INDEX IF EXISTS product_name_trgm_idx;
+DROP INDEX IF EXISTS product_name_unaccent_fts_idx;
+CREATE INDEX IF NOT EXISTS product_name_fts_idx ON product USING gin (to_tsvector('simple', name));
+DROP FUNCTION IF EXISTS f_unaccent(text);
@@ -0,0 +1,15 @@
+-- Search ignores accents, so "den neon" finds "Đèn Neon", and falls back to
+-- trigram similarity for typos. Creating the extensions needs a superuser or
+-- the CREATE privilege on the database.
+CREATE EXTENSION IF NOT EXISTS unaccent;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- unaccent() is only STABLE because its dictionary can change; the wrapper pins
+-- the dictionary so it can be used in indexes.
+CREATE OR REPLACE FUNCTION f_unaccent(text) RETURNS text
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+AS $$ SELECT public.unaccent('public.unaccent', $1) $$;
+
+DROP INDEX IF EXISTS product_name_fts_idx;
+CREATE INDEX IF NOT EXISTS product_name_unaccent_fts_idx ON product USING gin (to_tsvector('simple', f_unaccent(name)));
+CREATE INDEX IF NOT EXISTS product_name_trgm_idx ON product USING gin (f_unaccent(name) gin_trgm_ops);
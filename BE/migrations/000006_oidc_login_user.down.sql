@@ -0,0 +1 @@
+ALTER TABLE oidc_logins DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,2 @@
+-- A social login started by a signed in user links the identity to that user.
+ALTER TABLE oidc_logins ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users ON DELETE CASCADE;
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS product_search_document_tag ON tag;
+DROP TRIGGER IF EXISTS product_search_document_tag_product ON tag_product;
+DROP TRIGGER IF EXISTS product_search_document ON product;
+DROP FUNCTION IF EXISTS product_search_document_tag();
+DROP FUNCTION IF EXISTS product_search_document_tag_product();
+DROP FUNCTION IF EXISTS product_search_document_product();
+CREATE INDEX IF NOT EXISTS product_name_unaccent_fts_idx ON product USING gin (to_tsvector('simple', f_unaccent(name)));
+DROP INDEX IF EXISTS product_search_document_idx;
+ALTER TABLE product DROP COLUMN IF EXISTS search_document;
+DROP FUNCTION IF EXISTS product_search_document(uuid, text, text);
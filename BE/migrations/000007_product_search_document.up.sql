@@ -0,0 +1,66 @@
+-- Product search matches a stored document instead of building one per row, so
+-- it can use an index. The document weights the name above the tags above the
+-- description, with accents removed; triggers rebuild it when the product, its
+-- tags or the names of those tags change.
+CREATE OR REPLACE FUNCTION product_search_document(product_id uuid, name text, description text) RETURNS tsvector
+    LANGUAGE sql STABLE PARALLEL SAFE
+AS $$
+    SELECT setweight(to_tsvector('simple', f_unaccent(name)), 'A') ||
+        setweight(to_tsvector('simple', f_unaccent(coalesce((
+            SELECT string_agg(t.name, ' ') FROM tag_product tp JOIN tag t ON t.id = tp.tag_id
+            WHERE tp.product_id = product_search_document.product_id AND t.is_deleted = false), ''))), 'B') ||
+        setweight(to_tsvector('simple', f_unaccent(coalesce(description, ''))), 'C')
+$$;
+
+ALTER TABLE product ADD COLUMN IF NOT EXISTS search_document tsvector NOT NULL DEFAULT ''::tsvector;
+UPDATE product SET search_document = product_search_document(id, name, description);
+CREATE INDEX IF NOT EXISTS product_search_document_idx ON product USING gin (search_document);
+DROP INDEX IF EXISTS product_name_unaccent_fts_idx;
+
+CREATE OR REPLACE FUNCTION product_search_document_product() RETURNS trigger
+    LANGUAGE plpgsql
+AS $$
+BEGIN
+    NEW.search_document := product_search_document(NEW.id, NEW.name, NEW.description);
+    RETURN NEW;
+END
+$$;
+
+CREATE TRIGGER product_search_document
+    BEFORE INSERT OR UPDATE OF name, description ON product
+    FOR EACH ROW EXECUTE FUNCTION product_search_document_product();
+
+CREATE OR REPLACE FUNCTION product_search_document_tag_product() RETURNS trigger
+    LANGUAGE plpgsql
+AS $$
+BEGIN
+    IF TG_OP <> 'DELETE' THEN
+        UPDATE product SET search_document = product_search_document(id, name, description)
+        WHERE id = NEW.product_id;
+    END IF;
+    IF TG_OP <> 'INSERT' THEN
+        UPDATE product SET search_document = product_search_document(id, name, description)
+        WHERE id = OLD.product_id;
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER product_search_document_tag_product
+    AFTER INSERT OR UPDATE OR DELETE ON tag_product
+    FOR EACH ROW EXECUTE FUNCTION product_search_document_tag_product();
+
+CREATE OR REPLACE FUNCTION product_search_document_tag() RETURNS trigger
+    LANGUAGE plpgsql
+AS $$
+BEGIN
+    UPDATE product p SET search_document = product_search_document(p.id, p.name, p.description)
+    FROM tag_product tp
+    WHERE tp.tag_id = NEW.id AND tp.product_id = p.id;
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER product_search_document_tag
+    AFTER UPDATE OF name, is_deleted ON tag
+    FOR EACH ROW EXECUTE FUNCTION product_search_document_tag();
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations of the database schema, applied with
+// the migrate subcommand of the API or on startup with -db-automigrate. Add a
+// change as a new <version>_<name>.up.sql and .down.sql pair; never edit a
+// migration which has been applied somewhere.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS